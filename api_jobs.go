@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imageapi/config"
+	"imageapi/jobs"
+)
+
+var jobManager *jobs.Manager
+
+// initializeJobs opens the job store and starts the worker pool.
+func initializeJobs() {
+	settings := config.AppConfig.Jobs
+	store, err := jobs.OpenStore(settings.StorePath)
+	if err != nil {
+		log.Fatalf("Could not open job store: %v", err)
+	}
+
+	retention := time.Duration(settings.RetentionHours) * time.Hour
+	jobManager = jobs.NewManager(store, runGenerationJob, settings.Workers, settings.QueueSize, retention)
+	jobManager.Start()
+	log.Printf("Job queue started with %d workers (store: %s)", settings.Workers, settings.StorePath)
+}
+
+// runGenerationJob is the jobs.RunFunc for v1 generation jobs.
+func runGenerationJob(ctx context.Context, request json.RawMessage) (json.RawMessage, error) {
+	var apiReq APIGenerateRequest
+	if err := json.Unmarshal(request, &apiReq); err != nil {
+		return nil, err
+	}
+	resp, err := runAPIGeneration(ctx, apiReq)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resp)
+}
+
+// handleAPIJobs handles POST /api/v1/jobs, which queues a generation and
+// returns immediately with the new job.
+func handleAPIJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var apiReq APIGenerateRequest
+	if err := decodeJSONRequest(r, &apiReq); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// Reject obviously invalid requests now rather than as a failed job.
+	if err := validateAPIRequest(apiReq); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	request, err := json.Marshal(apiReq)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	job, err := jobManager.Submit(request)
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			writeAPIError(w, newAPIError(http.StatusServiceUnavailable, "%s, please retry later", err.Error()))
+			return
+		}
+		writeAPIError(w, err)
+		return
+	}
+
+	log.Printf("API: Queued job %s for model '%s'", job.ID, apiReq.Model)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleAPIJob handles GET (status) and DELETE (cancel) on /api/v1/jobs/{id}.
+func handleAPIJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/jobs/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		job *jobs.Job
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		job, err = jobManager.Get(id)
+	case http.MethodDelete:
+		job, err = jobManager.Cancel(id)
+		if err == nil {
+			log.Printf("API: Canceled job %s", id)
+		}
+	default:
+		http.Error(w, "Only GET and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeAPIError(w, newAPIError(http.StatusNotFound, "Job '%s' not found", id))
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		writeAPIError(w, newAPIError(http.StatusConflict, "Job '%s' has already finished with status '%s'", id, job.Status))
+		return
+	case err != nil:
+		writeAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
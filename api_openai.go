@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"imageapi/providers"
+)
+
+// --- OpenAI-compatible API ---
+//
+// These handlers expose the Images API of OpenAI (/v1/images/generations,
+// /v1/images/edits and /v1/models) so that existing OpenAI SDK clients can be
+// pointed at this server. Models use the same "Provider/model" names as the
+// v1 API.
+
+// maxOpenAIUploadSize limits the size of multipart uploads to /v1/images/edits.
+const maxOpenAIUploadSize = 32 << 20
+
+// openAIImageRequest is the JSON body of /v1/images/generations. The fields
+// after ResponseFormat are extensions mirroring the v1 API.
+type openAIImageRequest struct {
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+
+	NegativePrompt string           `json:"negative_prompt,omitempty"`
+	Seed           int64            `json:"seed,omitempty"`
+	Steps          int              `json:"steps,omitempty"`
+	Guidance       float64          `json:"guidance,omitempty"`
+	Strength       float64          `json:"strength,omitempty"`
+	LoRAs          []providers.LoRA `json:"loras,omitempty"`
+}
+
+// openAIImageData is one image of an OpenAI images response.
+type openAIImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// openAIImageResponse is the response of the OpenAI image endpoints.
+type openAIImageResponse struct {
+	Created int64             `json:"created"`
+	Data    []openAIImageData `json:"data"`
+	// Metadata is an extension holding the effective generation settings.
+	Metadata *generationMetadata `json:"metadata,omitempty"`
+}
+
+// openAIModel is one entry of the OpenAI /v1/models response.
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// openAIErrorResponse matches the error shape returned by OpenAI.
+type openAIErrorResponse struct {
+	Error openAIErrorDetail `json:"error"`
+}
+
+type openAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// writeOpenAIError writes err in the OpenAI error format, using the status
+// code from an *apiError when available.
+func writeOpenAIError(w http.ResponseWriter, err error) {
+	status := apiErrorStatus(err)
+	detail := openAIErrorDetail{Message: err.Error(), Type: "server_error"}
+	if status < http.StatusInternalServerError {
+		detail.Type = "invalid_request_error"
+	}
+	// OpenAI reports a single offending parameter; use the first invalid field.
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && len(apiErr.Fields) > 0 {
+		detail.Param = &apiErr.Fields[0].Field
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIErrorResponse{Error: detail})
+}
+
+// handleOpenAIModels handles GET /v1/models.
+func handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, newAPIError(http.StatusMethodNotAllowed, "Only GET method is allowed"))
+		return
+	}
+
+	models := []openAIModel{}
+	for providerName, provider := range providerRegistry {
+		for _, model := range providers.Models(provider) {
+			models = append(models, openAIModel{
+				ID:      providerName + "/" + model.Name,
+				Object:  "model",
+				OwnedBy: providerName,
+			})
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   models,
+	})
+}
+
+// handleOpenAIImageGenerations handles POST /v1/images/generations.
+func handleOpenAIImageGenerations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, newAPIError(http.StatusMethodNotAllowed, "Only POST method is allowed"))
+		return
+	}
+
+	var req openAIImageRequest
+	if err := decodeJSONRequest(r, &req); err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	genReq, err := newOpenAIGenerationRequest(req)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	serveOpenAIImages(w, r, genReq, req.ResponseFormat)
+}
+
+// handleOpenAIImageEdits handles POST /v1/images/edits, which takes a
+// multipart form with the image (as "image" or "image[]") and an optional mask.
+func handleOpenAIImageEdits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, newAPIError(http.StatusMethodNotAllowed, "Only POST method is allowed"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOpenAIUploadSize)
+	if err := r.ParseMultipartForm(maxOpenAIUploadSize); err != nil {
+		writeOpenAIError(w, newAPIError(http.StatusBadRequest, "Invalid multipart form: %v", err))
+		return
+	}
+
+	req := openAIImageRequest{
+		Model:          r.FormValue("model"),
+		Prompt:         r.FormValue("prompt"),
+		Size:           r.FormValue("size"),
+		ResponseFormat: r.FormValue("response_format"),
+		NegativePrompt: r.FormValue("negative_prompt"),
+	}
+	form := &formParser{r: r}
+	form.Int("n", &req.N)
+	form.Int64("seed", &req.Seed)
+	form.Int("steps", &req.Steps)
+	form.Float("guidance", &req.Guidance)
+	form.Float("strength", &req.Strength)
+	form.LoRAs("loras", &req.LoRAs)
+	if err := form.Err(); err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	genReq, err := newOpenAIGenerationRequest(req)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	imageBytes, filename, err := readFormFile(r, "image", "image[]")
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	if imageBytes == nil {
+		writeOpenAIError(w, newAPIError(http.StatusBadRequest, "'image' is required"))
+		return
+	}
+	// Only one input image reaches the provider, so reject further ones
+	// rather than silently dropping them.
+	if len(r.MultipartForm.File["image"])+len(r.MultipartForm.File["image[]"]) > 1 {
+		writeOpenAIError(w, newValidationError([]FieldError{{Field: "image", Message: "only one input image is supported"}}))
+		return
+	}
+	genReq.ImageBytes = imageBytes
+	genReq.ImageFilename = filename
+
+	maskBytes, _, err := readFormFile(r, "mask")
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	genReq.MaskBytes = maskBytes
+
+	serveOpenAIImages(w, r, genReq, req.ResponseFormat)
+}
+
+// newOpenAIGenerationRequest checks the OpenAI-specific fields of a request
+// and converts it to a generationRequest, which runGeneration validates.
+func newOpenAIGenerationRequest(req openAIImageRequest) (generationRequest, error) {
+	switch req.ResponseFormat {
+	case "", "url", "b64_json":
+	default:
+		return generationRequest{}, newValidationError([]FieldError{{Field: "response_format", Message: "must be 'url' or 'b64_json'"}})
+	}
+
+	width, height, err := parseOpenAISize(req.Size)
+	if err != nil {
+		return generationRequest{}, err
+	}
+
+	return generationRequest{
+		Model:          req.Model,
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Width:          width,
+		Height:         height,
+		Seed:           req.Seed,
+		Steps:          req.Steps,
+		Guidance:       req.Guidance,
+		Strength:       req.Strength,
+		LoRAs:          req.LoRAs,
+		N:              req.N,
+		InputSizeLimit: 1024,
+		SaveLocalCopy:  true,
+	}, nil
+}
+
+// parseOpenAISize parses an OpenAI size such as "1024x1024". An empty size
+// or "auto" leaves the choice to the server defaults.
+func parseOpenAISize(size string) (int, int, error) {
+	if size == "" || size == "auto" {
+		return 0, 0, nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(size), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, newValidationError([]FieldError{{Field: "size", Message: fmt.Sprintf("'%s' is not of the form WIDTHxHEIGHT", size)}})
+	}
+	return width, height, nil
+}
+
+// readFormFile returns the contents of the first of the given multipart
+// fields present in the request, or nil if none is.
+func readFormFile(r *http.Request, fields ...string) ([]byte, string, error) {
+	for _, field := range fields {
+		file, header, err := r.FormFile(field)
+		if errors.Is(err, http.ErrMissingFile) {
+			continue
+		}
+		if err != nil {
+			return nil, "", newAPIError(http.StatusBadRequest, "Invalid '%s' file: %v", field, err)
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, "", newAPIError(http.StatusBadRequest, "Failed to read '%s' file: %v", field, err)
+		}
+		return data, header.Filename, nil
+	}
+	return nil, "", nil
+}
+
+// serveOpenAIImages runs the generation and writes the OpenAI response, with
+// the images either uploaded to the result host ("url", the default) or
+// inlined as base64 ("b64_json").
+func serveOpenAIImages(w http.ResponseWriter, r *http.Request, genReq generationRequest, responseFormat string) {
+	if responseFormat == "" {
+		responseFormat = "url"
+	}
+	if responseFormat == "url" && resultImageHost == nil {
+		writeOpenAIError(w, newAPIError(http.StatusInternalServerError, "Image hosting is not configured, use response_format 'b64_json'"))
+		return
+	}
+
+	result, err := runGeneration(r.Context(), genReq)
+	if err != nil {
+		log.Printf("OpenAI API: generation failed: %v", err)
+		writeOpenAIError(w, err)
+		return
+	}
+
+	// Like OpenAI, report a rewritten prompt as revised_prompt.
+	var revisedPrompt string
+	if result.Metadata.Prompt != genReq.Prompt {
+		revisedPrompt = result.Metadata.Prompt
+	}
+
+	resp := openAIImageResponse{Created: time.Now().Unix(), Metadata: &result.Metadata}
+	uploadStart := time.Now()
+	for _, res := range result.Images {
+		if responseFormat == "b64_json" {
+			resp.Data = append(resp.Data, openAIImageData{B64JSON: base64.StdEncoding.EncodeToString(res.Bytes), RevisedPrompt: revisedPrompt})
+			continue
+		}
+		upload, err := resultImageHost.Upload(r.Context(), res.Bytes, res.Filename)
+		if err != nil {
+			writeOpenAIError(w, fmt.Errorf("Failed to upload final image: %w", err))
+			return
+		}
+		resp.Data = append(resp.Data, openAIImageData{URL: upload.URL, RevisedPrompt: revisedPrompt})
+	}
+	if responseFormat != "b64_json" {
+		resp.Metadata.Timings.addUpload(uploadStart)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	log.Printf("OpenAI API: Successfully returned %d image(s) for model '%s'", len(resp.Data), genReq.Model)
+}
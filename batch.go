@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"imageapi/config"
+	"imageapi/expand"
+	"imageapi/providers"
+)
+
+// batchSweep lists parameter values to try. Each is a comma-separated list;
+// seed and steps also take ranges such as "1-4" or "20-40:10".
+type batchSweep struct {
+	Seed  string `json:"seed,omitempty"`
+	Steps string `json:"steps,omitempty"`
+	Model string `json:"model,omitempty"` // Full model names
+}
+
+// APIBatchRequest is the body of the v1 batch endpoint: a generate request
+// whose prompt may use the dynamic prompt syntax, plus parameter sweeps.
+type APIBatchRequest struct {
+	APIGenerateRequest
+	Sweep batchSweep `json:"sweep,omitempty"`
+	// DryRun lists the expanded jobs without generating anything.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// batchJob is one generation of an expanded batch.
+type batchJob struct {
+	req       generationRequest
+	variables []expand.Variable
+}
+
+// batchItem reports one job of a batch.
+type batchItem struct {
+	Variables []expand.Variable `json:"variables"`
+	Prompt    string            `json:"prompt"`
+	Model     string            `json:"model,omitempty"`
+	Status    string            `json:"status"` // "success", "error", or "planned" in a dry run
+	Images    []APIImage        `json:"images,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	// Metadata holds the effective settings, to reproduce the generation.
+	Metadata *generationMetadata `json:"metadata,omitempty"`
+}
+
+// APIBatchResponse is the result of a batch. Status is "success" if every
+// job succeeded, "partial" if some failed and "error" if all failed.
+type APIBatchResponse struct {
+	Status    string      `json:"status"`
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Results   []batchItem `json:"results"`
+}
+
+// expandBatch expands the prompt of req and the sweeps into one job per
+// combination, the prompt's choices varying slowest. The jobs times n may not
+// exceed PROMPT_EXPANSION.MAX_JOBS generations. Every job is validated
+// up front, so a batch that cannot run fails before any provider is called.
+// Errors are *apiError values.
+func expandBatch(req generationRequest, sweep batchSweep) ([]batchJob, error) {
+	maxJobs := max(config.AppConfig.PromptExpansion.MaxJobs, 1)
+
+	var fieldErrors []FieldError
+	seeds, err := expand.ParseRange(sweep.Seed, maxJobs)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "sweep.seed", Message: err.Error()})
+	}
+	steps, err := expand.ParseRange(sweep.Steps, maxJobs)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "sweep.steps", Message: err.Error()})
+	}
+	var models []string
+	for _, model := range strings.Split(sweep.Model, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return nil, newValidationError(fieldErrors)
+	}
+
+	// MAX_JOBS caps the generations of a batch, and every job makes n of them.
+	perJob := max(req.N, 1)
+	if perJob > maxJobs {
+		return nil, newValidationError([]FieldError{{Field: "n", Message: fmt.Sprintf("%d images per job exceed the limit of %d per batch", perJob, maxJobs)}})
+	}
+	jobLimit := maxJobs / perJob
+	atN := ""
+	if perJob > 1 {
+		atN = fmt.Sprintf(" at n = %d", perJob)
+	}
+
+	sweeps := max(len(seeds), 1) * max(len(steps), 1) * max(len(models), 1)
+	if sweeps > jobLimit {
+		return nil, newValidationError([]FieldError{{Field: "sweep", Message: fmt.Sprintf("expands to %d jobs, the limit is %d%s", sweeps, jobLimit, atN)}})
+	}
+	prompts, err := expand.New(config.AppConfig.PromptExpansion.WildcardsDir).Expand(req.Prompt, jobLimit/sweeps)
+	if err != nil {
+		var limitErr *expand.LimitError
+		if errors.As(err, &limitErr) {
+			message := fmt.Sprintf("expands to more than %d jobs", jobLimit)
+			if sweeps > 1 {
+				message += fmt.Sprintf(" when combined with the %d swept values", sweeps)
+			}
+			return nil, newValidationError([]FieldError{{Field: "prompt", Message: message + atN}})
+		}
+		return nil, newValidationError([]FieldError{{Field: "prompt", Message: err.Error()}})
+	}
+
+	var jobs []batchJob
+	for _, prompt := range prompts {
+		job := batchJob{req: req, variables: append([]expand.Variable{}, prompt.Variables...)}
+		job.req.Prompt = prompt.Prompt
+		for _, model := range sweepValues(models) {
+			job := job.with(len(models) > 0, "model", model, func(r *generationRequest) { r.Model = model })
+			for _, seed := range sweepValues(seeds) {
+				job := job.with(len(seeds) > 0, "seed", strconv.FormatInt(seed, 10), func(r *generationRequest) { r.Seed = seed })
+				for _, step := range sweepValues(steps) {
+					job := job.with(len(steps) > 0, "steps", strconv.FormatInt(step, 10), func(r *generationRequest) { r.Steps = int(step) })
+					jobs = append(jobs, job)
+				}
+			}
+		}
+	}
+
+	for i, job := range jobs {
+		req := job.req
+		if err := applyStyle(&req); err != nil {
+			return nil, err
+		}
+		if _, _, err := validateGenerationRequest(&req); err != nil {
+			// Tell which job failed, keeping the invalid fields.
+			var apiErr *apiError
+			if len(jobs) > 1 && errors.As(err, &apiErr) {
+				return nil, &apiError{Status: apiErr.Status, Message: fmt.Sprintf("Job %d (%s): %s", i+1, describeVariables(job.variables), apiErr.Message), Fields: apiErr.Fields}
+			}
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+// sweepValues returns values, or a single zero value if nothing is swept.
+func sweepValues[T any](values []T) []T {
+	if len(values) == 0 {
+		return make([]T, 1)
+	}
+	return values
+}
+
+// with returns a copy of the job with set applied and, if swept, the value
+// recorded as a variable.
+func (j batchJob) with(swept bool, name, value string, set func(*generationRequest)) batchJob {
+	if !swept {
+		return j
+	}
+	set(&j.req)
+	j.variables = append(append([]expand.Variable(nil), j.variables...), expand.Variable{Name: name, Value: value})
+	return j
+}
+
+// describeVariables formats variables for error messages.
+func describeVariables(variables []expand.Variable) string {
+	if len(variables) == 0 {
+		return "no variables"
+	}
+	parts := make([]string, len(variables))
+	for i, v := range variables {
+		parts[i] = v.Name + "=" + v.Value
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fetchBatchInputs downloads the input image and mask of req once, instead of
+// once for every job of the batch.
+func fetchBatchInputs(ctx context.Context, req *generationRequest) error {
+	var err error
+	if len(req.ImageBytes) == 0 && req.ImageURL != "" {
+		log.Printf("Downloading image from provided URL: %s", req.ImageURL)
+		if req.ImageBytes, _, err = providers.DownloadFile(ctx, req.ImageURL); err != nil {
+			return newAPIError(http.StatusBadRequest, "Failed to download image from URL: %v", err)
+		}
+	}
+	if len(req.MaskBytes) == 0 && req.MaskURL != "" {
+		log.Printf("Downloading mask from provided URL: %s", req.MaskURL)
+		if req.MaskBytes, _, err = providers.DownloadFile(ctx, req.MaskURL); err != nil {
+			return newAPIError(http.StatusBadRequest, "Failed to download mask from URL: %v", err)
+		}
+	}
+	return nil
+}
+
+// runBatch runs the jobs, at most PROMPT_EXPANSION.CONCURRENCY at a time, and
+// hands every image to publish, which returns the URL to report. A failed
+// job is reported in its item and does not stop the others.
+func runBatch(ctx context.Context, jobs []batchJob, publish func(context.Context, resultImage) (string, error)) *APIBatchResponse {
+	items := make([]batchItem, len(jobs))
+	sem := make(chan struct{}, max(config.AppConfig.PromptExpansion.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		items[i] = batchItem{Variables: job.variables, Prompt: job.req.Prompt, Model: job.req.Model}
+		wg.Add(1)
+		go func(item *batchItem, req generationRequest) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				item.Status, item.Error = "error", ctx.Err().Error()
+				return
+			}
+
+			result, err := runGeneration(ctx, req)
+			if err != nil {
+				log.Printf("Batch job (%s) failed: %v", describeVariables(item.Variables), err)
+				item.Status, item.Error = "error", err.Error()
+				return
+			}
+			publishStart := time.Now()
+			for _, res := range result.Images {
+				url, err := publish(ctx, res)
+				if err != nil {
+					item.Status, item.Error, item.Images = "error", err.Error(), nil
+					return
+				}
+				item.Images = append(item.Images, APIImage{URL: url, Seed: res.Seed})
+			}
+			result.Metadata.Timings.addUpload(publishStart)
+			item.Status, item.Metadata = "success", &result.Metadata
+		}(&items[i], job.req)
+	}
+	wg.Wait()
+
+	resp := &APIBatchResponse{Total: len(items), Results: items}
+	for _, item := range items {
+		if item.Status == "success" {
+			resp.Succeeded++
+		}
+	}
+	switch resp.Succeeded {
+	case resp.Total:
+		resp.Status = "success"
+	case 0:
+		resp.Status = "error"
+	default:
+		resp.Status = "partial"
+	}
+	return resp
+}
+
+// plannedBatch reports the jobs of a dry run.
+func plannedBatch(jobs []batchJob) *APIBatchResponse {
+	resp := &APIBatchResponse{Status: "success", Total: len(jobs), Results: make([]batchItem, len(jobs))}
+	for i, job := range jobs {
+		resp.Results[i] = batchItem{Variables: job.variables, Prompt: job.req.Prompt, Model: job.req.Model, Status: "planned"}
+	}
+	return resp
+}
+
+// uploadResult publishes an image to the result image host.
+func uploadResult(ctx context.Context, res resultImage) (string, error) {
+	upload, err := resultImageHost.Upload(ctx, res.Bytes, res.Filename)
+	if err != nil {
+		return "", fmt.Errorf("Failed to upload final image: %w", err)
+	}
+	return upload.URL, nil
+}
+
+// inlineResult publishes an image as a data URL.
+func inlineResult(_ context.Context, res resultImage) (string, error) {
+	return "data:image/webp;base64," + base64.StdEncoding.EncodeToString(res.Bytes), nil
+}
+
+// handleBatch serves batches from the web UI, which sends the sweeps as the
+// form fields sweep_seed, sweep_steps and sweep_model.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	genReq, err := parseWebGenerationRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), apiErrorStatus(err))
+		return
+	}
+	sweep := batchSweep{Seed: r.FormValue("sweep_seed"), Steps: r.FormValue("sweep_steps"), Model: r.FormValue("sweep_model")}
+
+	publish := inlineResult
+	if config.AppConfig.Settings.UploadToImageHost {
+		if resultImageHost == nil {
+			http.Error(w, "Image hosting is not configured, cannot return final image URL. Set UPLOAD_TO_IMAGE_HOST=false to return image data directly.", http.StatusInternalServerError)
+			return
+		}
+		publish = uploadResult
+	}
+
+	jobs, err := expandBatch(genReq, sweep)
+	if err == nil {
+		err = fetchBatchInputs(r.Context(), &genReq)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), apiErrorStatus(err))
+		return
+	}
+	for i := range jobs {
+		jobs[i].req.ImageBytes, jobs[i].req.MaskBytes = genReq.ImageBytes, genReq.MaskBytes
+	}
+
+	log.Printf("Running batch of %d job(s)", len(jobs))
+	resp := runBatch(r.Context(), jobs, publish)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAPIBatch handles POST /api/v1/batch, which expands one request into
+// several generations and returns every result with its variables.
+func handleAPIBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "Only POST method is allowed"))
+		return
+	}
+
+	var apiReq APIBatchRequest
+	if err := decodeJSONRequest(r, &apiReq); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	genReq := apiReq.generationRequest()
+	jobs, err := expandBatch(genReq, apiReq.Sweep)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if apiReq.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plannedBatch(jobs))
+		return
+	}
+
+	// API calls always upload the results, so fail before doing any work.
+	if resultImageHost == nil {
+		writeAPIError(w, newAPIError(http.StatusInternalServerError, "Image hosting is not configured, cannot return final image URL."))
+		return
+	}
+	if err := fetchBatchInputs(r.Context(), &genReq); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	for i := range jobs {
+		jobs[i].req.ImageBytes, jobs[i].req.MaskBytes = genReq.ImageBytes, genReq.MaskBytes
+	}
+
+	log.Printf("API: Running batch of %d job(s)", len(jobs))
+	resp := runBatch(r.Context(), jobs, uploadResult)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
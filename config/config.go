@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +14,7 @@ import (
 // APIKeys holds the API keys for various services.
 type APIKeys struct {
 	NodeImage      string `json:"NODEIMAGE_API_KEY"`
+	FileInPic      string `json:"FILEINPIC_API_KEY"`
 	FalAI          string `json:"FAL_API_KEY"`
 	ModelScope     string `json:"MODELSCOPE_API_KEY"`
 	PollinationsAI string `json:"POLLINATIONS_AI_API_KEY"`
@@ -30,15 +33,279 @@ type Settings struct {
 	UploadToImageHost bool   `json:"UPLOAD_TO_IMAGE_HOST"`
 	WebPassword       string `json:"WEB_PASSWORD"`
 	SessionSecret     string `json:"SESSION_SECRET"`
+	// GenerationTimeoutSeconds bounds a single generation call when no
+	// provider or model specific timeout is configured.
+	GenerationTimeoutSeconds int `json:"GENERATION_TIMEOUT_SECONDS"`
+	// ValidationMode decides what happens to request parameters outside the
+	// model's limits: "clamp" adjusts them, "reject" fails the request.
+	ValidationMode string `json:"VALIDATION_MODE"`
+	// ModelCatalogTTLMinutes is how often the model lists of providers that
+	// support discovery are refreshed from upstream. Zero disables discovery.
+	ModelCatalogTTLMinutes int `json:"MODEL_CATALOG_TTL_MINUTES"`
+	// StylesDir holds the JSON files of the style presets.
+	StylesDir string `json:"STYLES_DIR"`
+}
+
+// ProviderSettings holds optional per-provider tuning, keyed by provider name
+// (e.g. "Modelscope") in the PROVIDERS section of conf.json.
+type ProviderSettings struct {
+	TimeoutSeconds int `json:"TIMEOUT_SECONDS"`
+	// ModelTimeoutSeconds overrides TimeoutSeconds for individual models,
+	// keyed by the model name without the provider prefix.
+	ModelTimeoutSeconds map[string]int `json:"MODEL_TIMEOUT_SECONDS"`
+	// Retry replaces the global RETRY settings for this provider.
+	Retry *RetrySettings `json:"RETRY"`
+	// ProxyURL and CAFile override the global HTTP settings for this provider.
+	ProxyURL string `json:"PROXY_URL"`
+	CAFile   string `json:"CA_FILE"`
+	// Asynchronous providers such as ModelScope wait PollIntervalSeconds
+	// before the first status poll, growing the wait by half after every
+	// poll up to MaxPollIntervalSeconds, and give up after MaxPollSeconds.
+	// Zero keeps the provider's defaults.
+	PollIntervalSeconds    int `json:"POLL_INTERVAL_SECONDS"`
+	MaxPollIntervalSeconds int `json:"MAX_POLL_INTERVAL_SECONDS"`
+	MaxPollSeconds         int `json:"MAX_POLL_SECONDS"`
+}
+
+// HTTPSettings configures the shared transport of all outbound requests to
+// providers and image hosts.
+type HTTPSettings struct {
+	DialTimeoutSeconds           int `json:"DIAL_TIMEOUT_SECONDS"`
+	TLSHandshakeTimeoutSeconds   int `json:"TLS_HANDSHAKE_TIMEOUT_SECONDS"`
+	ResponseHeaderTimeoutSeconds int `json:"RESPONSE_HEADER_TIMEOUT_SECONDS"` // 0 means no limit
+	IdleConnTimeoutSeconds       int `json:"IDLE_CONN_TIMEOUT_SECONDS"`
+	MaxIdleConnsPerHost          int `json:"MAX_IDLE_CONNS_PER_HOST"`
+	// ProxyURL routes requests through a proxy, e.g. "http://proxy:3128".
+	// Empty uses HTTP_PROXY/HTTPS_PROXY; "direct" disables proxying.
+	ProxyURL string `json:"PROXY_URL"`
+	// CAFile is a PEM file of extra certificate authorities to trust.
+	CAFile string `json:"CA_FILE"`
+}
+
+// RetrySettings configures how failed upstream requests are retried.
+type RetrySettings struct {
+	MaxRetries  int `json:"MAX_RETRIES"`   // Retries after the first attempt; 0 disables retrying
+	BaseDelayMs int `json:"BASE_DELAY_MS"` // Delay before the first retry, doubled for each further one
+	MaxDelayMs  int `json:"MAX_DELAY_MS"`  // Upper bound of the backoff delay
+	// BudgetSeconds bounds the total time of a request including retries. 0 means no limit.
+	BudgetSeconds int `json:"BUDGET_SECONDS"`
+}
+
+// ParamRangeSettings declares the accepted range of a numeric model parameter.
+type ParamRangeSettings struct {
+	Min     float64 `json:"MIN"`
+	Max     float64 `json:"MAX"`
+	Default float64 `json:"DEFAULT"`
+}
+
+// ModelSettings declares a model and its capabilities for providers that are
+// defined in conf.json rather than in code.
+type ModelSettings struct {
+	Name            string                        `json:"NAME"`
+	SupportedParams []string                      `json:"SUPPORTED_PARAMS"`
+	MaxWidth        int                           `json:"MAX_WIDTH"`
+	MaxHeight       int                           `json:"MAX_HEIGHT"`
+	MinSteps        int                           `json:"MIN_STEPS"`
+	MaxSteps        int                           `json:"MAX_STEPS"`
+	DefaultSteps    int                           `json:"DEFAULT_STEPS"`
+	MaxBatch        int                           `json:"MAX_BATCH"`
+	SizeMultiple    int                           `json:"SIZE_MULTIPLE"`
+	ParamRanges     map[string]ParamRangeSettings `json:"PARAM_RANGES"`
+
+	// Capability flags; when omitted they are derived from SUPPORTED_PARAMS.
+	Tasks          []string `json:"TASKS"`       // "text-to-image", "edit", "inpaint", "upscale"
+	ImageInput     string   `json:"IMAGE_INPUT"` // "none", "optional" or "required"
+	MaxInputImages int      `json:"MAX_INPUT_IMAGES"`
+	InputTransport string   `json:"INPUT_TRANSPORT"` // "bytes" or "url"
+	// EnglishPrompt translates non-English prompts for this model when
+	// prompt translation is enabled.
+	EnglishPrompt bool `json:"ENGLISH_PROMPT"`
+}
+
+// OpenAICompatibleSettings defines one backend that speaks the OpenAI Images API.
+// Each entry becomes its own provider, registered under Name.
+type OpenAICompatibleSettings struct {
+	Name    string `json:"NAME"`
+	BaseURL string `json:"BASE_URL"`
+	APIKey  string `json:"API_KEY"`
+	// ResponseFormat is "b64_json", "url" or empty for the upstream default.
+	ResponseFormat string          `json:"RESPONSE_FORMAT"`
+	Models         []ModelSettings `json:"MODELS"`
+}
+
+// HTTPRequestTemplate describes an HTTP request to an upstream. URL, header
+// values and Body are Go text/templates evaluated over the generation input.
+type HTTPRequestTemplate struct {
+	Method  string            `json:"METHOD"` // Defaults to POST when BODY is set, GET otherwise
+	URL     string            `json:"URL"`
+	Headers map[string]string `json:"HEADERS"`
+	Body    string            `json:"BODY"`
+}
+
+// HTTPResponseRule describes where the image is found in an upstream response.
+type HTTPResponseRule struct {
+	// Type is "raw" (the body is the image), "base64", "data_url" or "url".
+	Type string `json:"TYPE"`
+	// Path is a dotted JSON path such as "data.0.b64_json" to the value, or to
+	// an array of values, holding the image. Unused for "raw".
+	Path string `json:"PATH"`
+}
+
+// HTTPPollSettings configures polling for upstreams that answer with a task
+// ID and produce the image asynchronously.
+type HTTPPollSettings struct {
+	TaskIDPath string              `json:"TASK_ID_PATH"` // JSON path of the task ID in the submit response
+	Request    HTTPRequestTemplate `json:"REQUEST"`      // Status request; templates can use {{.TaskID}}
+	StatusPath string              `json:"STATUS_PATH"`  // JSON path of the task status in the status response
+	// SuccessValues and FailureValues are the status values that end polling.
+	SuccessValues   []string `json:"SUCCESS_VALUES"`
+	FailureValues   []string `json:"FAILURE_VALUES"`
+	ErrorPath       string   `json:"ERROR_PATH"` // Optional JSON path of the failure reason
+	IntervalSeconds int      `json:"INTERVAL_SECONDS"`
+	MaxAttempts     int      `json:"MAX_ATTEMPTS"`
+}
+
+// HTTPProviderSettings defines a provider entirely from configuration: the
+// request to send, how to extract the image from the response and, for
+// asynchronous upstreams, how to poll for the result.
+type HTTPProviderSettings struct {
+	Name     string              `json:"NAME"`
+	Request  HTTPRequestTemplate `json:"REQUEST"`
+	Response HTTPResponseRule    `json:"RESPONSE"`
+	// Poll is optional; the image is then read from the final status response.
+	Poll   *HTTPPollSettings `json:"POLL"`
+	Models []ModelSettings   `json:"MODELS"`
+}
+
+// FalModelSettings declares a Fal.ai model endpoint in addition to the
+// built-in ones, along with how request parameters are mapped onto it.
+type FalModelSettings struct {
+	ModelSettings
+	// Endpoint is the Fal model path, e.g. "fal-ai/flux/schnell".
+	// Defaults to "fal-ai/" followed by NAME.
+	Endpoint string `json:"ENDPOINT"`
+	// ImageSize is "object" (the default) to send {"width", "height"}, or
+	// "preset" to send the closest named size such as "landscape_4_3".
+	ImageSize string `json:"IMAGE_SIZE"`
+	// ImageField is "image_urls" (the default) or "image_url".
+	ImageField string `json:"IMAGE_FIELD"`
+	// SafetyChecker is sent as enable_safety_checker; omitted when unset.
+	SafetyChecker *bool `json:"SAFETY_CHECKER"`
+	// ExtraParams are added verbatim to every request for this model.
+	ExtraParams map[string]interface{} `json:"EXTRA_PARAMS"`
+}
+
+// PromptOptimizerSettings configures the backends behind the prompt
+// optimization endpoints.
+type PromptOptimizerSettings struct {
+	// Backends are tried in order until one succeeds.
+	Backends []PromptOptimizerBackend `json:"BACKENDS"`
+	// Styles adds or replaces system prompts selectable by name.
+	Styles         map[string]string `json:"STYLES"`
+	TimeoutSeconds int               `json:"TIMEOUT_SECONDS"` // Per backend attempt; 0 means no limit
+}
+
+// PromptOptimizerBackend declares one prompt optimization backend.
+type PromptOptimizerBackend struct {
+	Type string `json:"TYPE"` // "dreamifly", "pollinations" or "openai"
+	// Name identifies the backend in requests and selects its PROVIDERS
+	// entry for retries and proxies. Defaults to "Dreamifly" and
+	// "Pollinations_ai"; required for "openai".
+	Name    string `json:"NAME"`
+	BaseURL string `json:"BASE_URL"` // openai: chat API base including the version
+	APIKey  string `json:"API_KEY"`  // pollinations defaults to POLLINATIONS_AI_API_KEY
+	Model   string `json:"MODEL"`
+}
+
+// TranslationSettings configures translating non-English prompts into
+// English for models flagged with ENGLISH_PROMPT.
+type TranslationSettings struct {
+	Enabled bool `json:"ENABLED"`
+	// Translator is "llm" (the default) or "dictionary".
+	Translator string `json:"TRANSLATOR"`
+	// Backend is the chat model of the "llm" translator, declared like a
+	// PROMPT_OPTIMIZER backend of TYPE "pollinations" or "openai".
+	Backend PromptOptimizerBackend `json:"BACKEND"`
+	// DictionaryFile is the JSON phrase list of the "dictionary" translator.
+	DictionaryFile string `json:"DICTIONARY_FILE"`
+	TimeoutSeconds int    `json:"TIMEOUT_SECONDS"` // 0 means no limit beyond the generation timeout
+}
+
+// PromptExpansionSettings configures the batch endpoints, which expand the
+// dynamic prompt syntax and parameter sweeps of one request into several
+// generations.
+type PromptExpansionSettings struct {
+	// WildcardsDir holds the __name__ wildcard files, one option per line.
+	WildcardsDir string `json:"WILDCARDS_DIR"`
+	// MaxJobs caps the number of generations a single request may expand to.
+	MaxJobs     int `json:"MAX_JOBS"`
+	Concurrency int `json:"CONCURRENCY"` // Generations of one request run in parallel
+}
+
+// ImageHostSettings selects the image hosting backend used for each purpose.
+// Supported backends: "nodeimage", "fileinpic", "local".
+type ImageHostSettings struct {
+	// TempInput hosts input images for providers that require an image URL.
+	TempInput string `json:"TEMP_INPUT"`
+	// Result hosts final images when UPLOAD_TO_IMAGE_HOST is enabled.
+	Result string `json:"RESULT"`
+	// FileInPicBaseURL is the address of the fileinpic instance.
+	FileInPicBaseURL string `json:"FILEINPIC_BASE_URL"`
+
+	// LocalBaseURL is the public address of this server, used to build the
+	// signed URLs of the "local" host.
+	LocalBaseURL string `json:"LOCAL_BASE_URL"`
+	// LocalSecret signs local URLs. Defaults to SESSION_SECRET, or to a random
+	// per-process key if that is not set either.
+	LocalSecret string `json:"LOCAL_SECRET"`
+	// LocalURLTTLMinutes is how long a signed local URL stays valid.
+	LocalURLTTLMinutes int `json:"LOCAL_URL_TTL_MINUTES"`
+	// LocalTempDir stores temporary input images served by the "local" host.
+	LocalTempDir string `json:"LOCAL_TEMP_DIR"`
+	// LocalResultDir stores the result images served by the "local" host.
+	// Files are removed once their links have expired.
+	LocalResultDir string `json:"LOCAL_RESULT_DIR"`
+}
+
+// JobSettings configures the asynchronous job queue of the v1 API.
+type JobSettings struct {
+	Workers        int    `json:"WORKERS"`
+	QueueSize      int    `json:"QUEUE_SIZE"`
+	StorePath      string `json:"STORE_PATH"`
+	RetentionHours int    `json:"RETENTION_HOURS"`
+}
+
+// HealthSettings configures the circuit breaker applied to every provider.
+type HealthSettings struct {
+	FailureThreshold int `json:"FAILURE_THRESHOLD"` // Consecutive failures that open the circuit
+	OpenSeconds      int `json:"OPEN_SECONDS"`      // Cooldown before probing the upstream again
+	HalfOpenProbes   int `json:"HALF_OPEN_PROBES"`  // Concurrent probe requests while half-open
+	WindowSize       int `json:"WINDOW_SIZE"`       // Recent calls kept for success rate and latency
 }
 
 // Config holds the entire application configuration.
 type Config struct {
-	APIKeys               APIKeys               `json:"API_KEYS"`
-	CloudflareCredentials CloudflareCredentials `json:"CLOUDFLARE_CREDENTIALS"`
-	Settings              Settings              `json:"SETTINGS"`
+	APIKeys               APIKeys                     `json:"API_KEYS"`
+	CloudflareCredentials CloudflareCredentials       `json:"CLOUDFLARE_CREDENTIALS"`
+	Settings              Settings                    `json:"SETTINGS"`
+	Providers             map[string]ProviderSettings `json:"PROVIDERS"`
+	Jobs                  JobSettings                 `json:"JOBS"`
+	Health                HealthSettings              `json:"HEALTH"`
+	Retry                 RetrySettings               `json:"RETRY"`
+	HTTP                  HTTPSettings                `json:"HTTP"`
+	ImageHost             ImageHostSettings           `json:"IMAGE_HOST"`
+	OpenAICompatible      []OpenAICompatibleSettings  `json:"OPENAI_COMPATIBLE"`
+	HTTPProviders         []HTTPProviderSettings      `json:"HTTP_PROVIDERS"`
+	FalModels             []FalModelSettings          `json:"FAL_MODELS"`
+	PromptOptimizer       PromptOptimizerSettings     `json:"PROMPT_OPTIMIZER"`
+	Translation           TranslationSettings         `json:"TRANSLATION"`
+	PromptExpansion       PromptExpansionSettings     `json:"PROMPT_EXPANSION"`
 }
 
+// DefaultSessionSecret is the SESSION_SECRET used when none is configured.
+// It is public, so nothing that needs to stay secret may be keyed with it.
+const DefaultSessionSecret = "a_very_long_and_random_secret_string"
+
 // AppConfig is the global configuration instance.
 var AppConfig *Config
 
@@ -47,9 +314,59 @@ func LoadConfig() {
 	// 1. Set default values
 	AppConfig = &Config{
 		Settings: Settings{
-			SaveLocalCopy:     true,
-			UploadToImageHost: true,
-			SessionSecret:     "a_very_long_and_random_secret_string",
+			SaveLocalCopy:            true,
+			UploadToImageHost:        true,
+			SessionSecret:            DefaultSessionSecret,
+			GenerationTimeoutSeconds: 300,
+			ValidationMode:           "clamp",
+			ModelCatalogTTLMinutes:   60,
+			StylesDir:                "styles",
+		},
+		ImageHost: ImageHostSettings{
+			TempInput:          "nodeimage",
+			Result:             "nodeimage",
+			LocalURLTTLMinutes: 1440,
+			LocalTempDir:       "temp_inputs",
+			LocalResultDir:     "temp_results",
+		},
+		Jobs: JobSettings{
+			Workers:        2,
+			QueueSize:      100,
+			StorePath:      "data/jobs.json",
+			RetentionHours: 24,
+		},
+		Health: HealthSettings{
+			FailureThreshold: 5,
+			OpenSeconds:      60,
+			HalfOpenProbes:   1,
+			WindowSize:       50,
+		},
+		Retry: RetrySettings{
+			MaxRetries:    2,
+			BaseDelayMs:   500,
+			MaxDelayMs:    10000,
+			BudgetSeconds: 60,
+		},
+		HTTP: HTTPSettings{
+			DialTimeoutSeconds:           10,
+			TLSHandshakeTimeoutSeconds:   10,
+			ResponseHeaderTimeoutSeconds: 300,
+			IdleConnTimeoutSeconds:       90,
+			MaxIdleConnsPerHost:          10,
+		},
+		PromptOptimizer: PromptOptimizerSettings{
+			Backends:       []PromptOptimizerBackend{{Type: "dreamifly"}, {Type: "pollinations"}},
+			TimeoutSeconds: 60,
+		},
+		Translation: TranslationSettings{
+			Translator:     "llm",
+			Backend:        PromptOptimizerBackend{Type: "pollinations"},
+			TimeoutSeconds: 30,
+		},
+		PromptExpansion: PromptExpansionSettings{
+			WildcardsDir: "wildcards",
+			MaxJobs:      16,
+			Concurrency:  2,
 		},
 	}
 
@@ -82,6 +399,9 @@ func loadFromEnv() {
 	if key := os.Getenv("NODEIMAGE_API_KEY"); key != "" {
 		AppConfig.APIKeys.NodeImage = key
 	}
+	if key := os.Getenv("FILEINPIC_API_KEY"); key != "" {
+		AppConfig.APIKeys.FileInPic = key
+	}
 	if key := os.Getenv("FAL_API_KEY"); key != "" {
 		AppConfig.APIKeys.FalAI = key
 	}
@@ -120,4 +440,142 @@ func loadFromEnv() {
 	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
 		AppConfig.Settings.SessionSecret = secret
 	}
+	if val := os.Getenv("GENERATION_TIMEOUT_SECONDS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.Settings.GenerationTimeoutSeconds = n
+		}
+	}
+	if mode := os.Getenv("VALIDATION_MODE"); mode != "" {
+		AppConfig.Settings.ValidationMode = mode
+	}
+	if val := os.Getenv("MODEL_CATALOG_TTL_MINUTES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.Settings.ModelCatalogTTLMinutes = n
+		}
+	}
+	if dir := os.Getenv("STYLES_DIR"); dir != "" {
+		AppConfig.Settings.StylesDir = dir
+	}
+
+	// Image hosts
+	if name := os.Getenv("IMAGE_HOST_TEMP_INPUT"); name != "" {
+		AppConfig.ImageHost.TempInput = name
+	}
+	if name := os.Getenv("IMAGE_HOST_RESULT"); name != "" {
+		AppConfig.ImageHost.Result = name
+	}
+	if baseURL := os.Getenv("FILEINPIC_BASE_URL"); baseURL != "" {
+		AppConfig.ImageHost.FileInPicBaseURL = baseURL
+	}
+	if baseURL := os.Getenv("LOCAL_BASE_URL"); baseURL != "" {
+		AppConfig.ImageHost.LocalBaseURL = baseURL
+	}
+	if secret := os.Getenv("LOCAL_SECRET"); secret != "" {
+		AppConfig.ImageHost.LocalSecret = secret
+	}
+	if val := os.Getenv("LOCAL_URL_TTL_MINUTES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.ImageHost.LocalURLTTLMinutes = n
+		}
+	}
+	if dir := os.Getenv("LOCAL_RESULT_DIR"); dir != "" {
+		AppConfig.ImageHost.LocalResultDir = dir
+	}
+
+	// Jobs
+	if val := os.Getenv("JOB_WORKERS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.Jobs.Workers = n
+		}
+	}
+	if path := os.Getenv("JOB_STORE_PATH"); path != "" {
+		AppConfig.Jobs.StorePath = path
+	}
+
+	// Outbound HTTP
+	if proxy := os.Getenv("OUTBOUND_PROXY_URL"); proxy != "" {
+		AppConfig.HTTP.ProxyURL = proxy
+	}
+	if file := os.Getenv("OUTBOUND_CA_FILE"); file != "" {
+		AppConfig.HTTP.CAFile = file
+	}
+
+	// Retries
+	if val := os.Getenv("RETRY_MAX_RETRIES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.Retry.MaxRetries = n
+		}
+	}
+
+	// Prompt optimizer: reorders the configured backends, or adds backends
+	// of the listed types that need no further settings.
+	if val := os.Getenv("PROMPT_OPTIMIZER_BACKENDS"); val != "" {
+		var backends []PromptOptimizerBackend
+		for _, name := range strings.Split(val, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			backend := PromptOptimizerBackend{Type: strings.ToLower(name)}
+			for _, b := range AppConfig.PromptOptimizer.Backends {
+				if strings.EqualFold(b.Name, name) || (b.Name == "" && strings.EqualFold(b.Type, name)) {
+					backend = b
+					break
+				}
+			}
+			backends = append(backends, backend)
+		}
+		AppConfig.PromptOptimizer.Backends = backends
+	}
+
+	// Prompt translation
+	if val := os.Getenv("PROMPT_TRANSLATION"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			AppConfig.Translation.Enabled = b
+		}
+	}
+	if translator := os.Getenv("PROMPT_TRANSLATOR"); translator != "" {
+		AppConfig.Translation.Translator = translator
+	}
+	if file := os.Getenv("PROMPT_DICTIONARY_FILE"); file != "" {
+		AppConfig.Translation.DictionaryFile = file
+	}
+
+	// Prompt expansion
+	if dir := os.Getenv("WILDCARDS_DIR"); dir != "" {
+		AppConfig.PromptExpansion.WildcardsDir = dir
+	}
+	if val := os.Getenv("PROMPT_EXPANSION_MAX_JOBS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			AppConfig.PromptExpansion.MaxJobs = n
+		}
+	}
+}
+
+// GenerationTimeout returns the deadline to apply to a single generation call.
+// A model-specific timeout wins over a provider-wide one, which in turn wins
+// over the global GENERATION_TIMEOUT_SECONDS setting. Zero means no deadline.
+func (c *Config) GenerationTimeout(providerName, modelName string) time.Duration {
+	seconds := c.Settings.GenerationTimeoutSeconds
+	if ps, ok := c.Providers[providerName]; ok {
+		if ps.TimeoutSeconds > 0 {
+			seconds = ps.TimeoutSeconds
+		}
+		if s, ok := ps.ModelTimeoutSeconds[modelName]; ok && s > 0 {
+			seconds = s
+		}
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RetrySettings returns the retry settings for the named provider or image
+// host: its own RETRY entry in PROVIDERS if present, the global RETRY otherwise.
+func (c *Config) RetrySettings(name string) RetrySettings {
+	if ps, ok := c.Providers[name]; ok && ps.Retry != nil {
+		return *ps.Retry
+	}
+	return c.Retry
 }
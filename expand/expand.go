@@ -0,0 +1,290 @@
+// Package expand implements the dynamic prompt syntax: {a|b} alternations
+// and __name__ wildcards read from files. A prompt expands into one prompt
+// for every combination of its choices.
+package expand
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxWildcardDepth bounds wildcards that refer to other wildcards, which also
+// stops a wildcard that refers to itself.
+const maxWildcardDepth = 8
+
+// wildcardName matches the names allowed between double underscores: path
+// segments of letters, digits, '_' and '-', so a name never leaves the
+// wildcards directory.
+var wildcardName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*(/[A-Za-z0-9][A-Za-z0-9_-]*)*$`)
+
+// Variable records one choice made while expanding a prompt.
+type Variable struct {
+	Name  string `json:"name"` // The choice as written, e.g. "{red|blue}" or "__color__"
+	Value string `json:"value"`
+}
+
+// Expansion is one prompt produced by expanding a template, together with
+// the choices that produced it.
+type Expansion struct {
+	Prompt    string
+	Variables []Variable
+}
+
+// LimitError is returned when an expansion would produce more than Limit results.
+type LimitError struct {
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("expands to more than %d combinations", e.Limit)
+}
+
+// Expander expands prompts, reading wildcards from a directory.
+type Expander struct {
+	dir string
+}
+
+// New creates an expander whose __name__ wildcards are read from
+// dir/name.txt, one option per line. Blank lines and lines starting with
+// '#' are ignored, and options may use the syntax themselves.
+func New(wildcardsDir string) *Expander {
+	return &Expander{dir: wildcardsDir}
+}
+
+// Expand returns every prompt the template expands to, in order: the first
+// choice in the template varies slowest. A template without any syntax
+// expands to itself. If there would be more than limit prompts, Expand
+// returns a *LimitError without expanding them. Braces without a '|' inside
+// are kept as written, and a backslash escapes any of `{}|_\`.
+func (e *Expander) Expand(template string, limit int) ([]Expansion, error) {
+	p := &parser{expander: e, src: template}
+	seq, _ := p.sequence(false)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if seq.count(limit) > limit {
+		return nil, &LimitError{Limit: limit}
+	}
+
+	partials := seq.expand()
+	expansions := make([]Expansion, len(partials))
+	for i, partial := range partials {
+		expansions[i] = Expansion{Prompt: partial.text, Variables: partial.variables}
+	}
+	return expansions, nil
+}
+
+// node is a part of a parsed template: either text or a choice.
+type node interface{}
+
+// text is literal template text.
+type text string
+
+// choice is an alternation or a wildcard; each option is a sequence.
+type choice struct {
+	source  string
+	options []sequence
+}
+
+// sequence is a parsed template or option.
+type sequence []node
+
+// count returns the number of prompts s expands to, or limit+1 if there are more.
+func (s sequence) count(limit int) int {
+	total := 1
+	for _, n := range s {
+		c, ok := n.(choice)
+		if !ok {
+			continue
+		}
+		options := 0
+		for _, option := range c.options {
+			options = min(options+option.count(limit), limit+1)
+		}
+		total = min(total*options, limit+1)
+	}
+	return total
+}
+
+// partial is a prompt being expanded.
+type partial struct {
+	text      string
+	variables []Variable
+}
+
+// expand returns every combination of the choices in s.
+func (s sequence) expand() []partial {
+	partials := []partial{{}}
+	for _, n := range s {
+		switch n := n.(type) {
+		case text:
+			for i := range partials {
+				partials[i].text += string(n)
+			}
+		case choice:
+			var options []partial
+			for _, option := range n.options {
+				options = append(options, option.expand()...)
+			}
+			next := make([]partial, 0, len(partials)*len(options))
+			for _, p := range partials {
+				for _, o := range options {
+					variables := make([]Variable, 0, len(p.variables)+1+len(o.variables))
+					variables = append(variables, p.variables...)
+					variables = append(variables, Variable{Name: n.source, Value: o.text})
+					variables = append(variables, o.variables...)
+					next = append(next, partial{text: p.text + o.text, variables: variables})
+				}
+			}
+			partials = next
+		}
+	}
+	return partials
+}
+
+// parser turns a template into a sequence. The first error is kept in err.
+type parser struct {
+	expander *Expander
+	src      string
+	pos      int
+	depth    int // Wildcard nesting
+	err      error
+}
+
+// sequence parses up to the end of the template or, inside braces, up to the
+// next '|' or '}'. It returns the parsed sequence and the byte that ended it,
+// or zero at the end of the template.
+func (p *parser) sequence(inBraces bool) (sequence, byte) {
+	var seq sequence
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			seq = append(seq, text(literal.String()))
+			literal.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) && p.err == nil {
+		c := p.src[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.src) && strings.IndexByte(`{}|_\`, p.src[p.pos+1]) >= 0:
+			literal.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		case inBraces && (c == '|' || c == '}'):
+			p.pos++
+			flush()
+			return seq, c
+		case c == '{':
+			flush()
+			seq = append(seq, p.braces()...)
+		case strings.HasPrefix(p.src[p.pos:], "__"):
+			if n, ok := p.wildcard(); ok {
+				flush()
+				seq = append(seq, n)
+			} else {
+				literal.WriteString("__")
+				p.pos += 2
+			}
+		default:
+			literal.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	return seq, 0
+}
+
+// braces parses the group starting at the current '{'. A group with several
+// options is a choice; anything else is kept as literal text.
+func (p *parser) braces() sequence {
+	start := p.pos
+	p.pos++
+
+	var options []sequence
+	var end byte = '|'
+	for end == '|' {
+		var option sequence
+		option, end = p.sequence(true)
+		options = append(options, option)
+	}
+	if end == '}' && len(options) > 1 {
+		return sequence{choice{source: p.src[start:p.pos], options: options}}
+	}
+
+	// Not an alternation: restore the braces and separators around the options.
+	literal := sequence{text("{")}
+	for i, option := range options {
+		if i > 0 {
+			literal = append(literal, text("|"))
+		}
+		literal = append(literal, option...)
+	}
+	if end == '}' {
+		literal = append(literal, text("}"))
+	}
+	return literal
+}
+
+// wildcard parses the __name__ at the current position. It reports false,
+// consuming nothing, if no valid name follows.
+func (p *parser) wildcard() (node, bool) {
+	rest := p.src[p.pos+2:]
+	end := strings.Index(rest, "__")
+	if end < 0 || !wildcardName.MatchString(rest[:end]) {
+		return nil, false
+	}
+	name := rest[:end]
+	source := "__" + name + "__"
+	p.pos += len(source)
+
+	if p.depth >= maxWildcardDepth {
+		p.err = fmt.Errorf("wildcards are nested too deeply at %s", source)
+		return nil, true
+	}
+	lines, err := p.expander.wildcard(name)
+	if err != nil {
+		p.err = err
+		return nil, true
+	}
+
+	c := choice{source: source}
+	for _, line := range lines {
+		sub := &parser{expander: p.expander, src: line, depth: p.depth + 1}
+		option, _ := sub.sequence(false)
+		if sub.err != nil {
+			p.err = sub.err
+			return nil, true
+		}
+		c.options = append(c.options, option)
+	}
+	return c, true
+}
+
+// wildcard reads the options of the wildcard name.
+func (e *Expander) wildcard(name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(e.dir, filepath.FromSlash(name)+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown wildcard __%s__", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wildcard __%s__: %w", name, err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("wildcard __%s__ has no options", name)
+	}
+	return lines, nil
+}
@@ -0,0 +1,152 @@
+package expand
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestExpander returns an expander reading the given wildcards, keyed by
+// name, from a temporary directory.
+func newTestExpander(t *testing.T, wildcards map[string]string) *Expander {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range wildcards {
+		path := filepath.Join(dir, filepath.FromSlash(name)+".txt")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(dir)
+}
+
+// prompts returns the prompts of expansions.
+func prompts(expansions []Expansion) []string {
+	out := make([]string, len(expansions))
+	for i, e := range expansions {
+		out[i] = e.Prompt
+	}
+	return out
+}
+
+func TestExpand(t *testing.T) {
+	e := newTestExpander(t, map[string]string{
+		"color":        "red\n\n# a comment\nblue\n",
+		"animal":       "cat\n__color__ dog\n",
+		"styles/light": "soft light\n",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{"plain", "a cat", []string{"a cat"}},
+		{"alternation", "a {red|blue} cat", []string{"a red cat", "a blue cat"}},
+		{"first choice varies slowest", "{a|b}{1|2}", []string{"a1", "a2", "b1", "b2"}},
+		{"nested", "{a {x|y}|b}", []string{"a x", "a y", "b"}},
+		{"empty option", "cat{| hat}", []string{"cat", "cat hat"}},
+		{"braces without options", "{json} {}", []string{"{json} {}"}},
+		{"unclosed braces", "a {b|c", []string{"a {b|c"}},
+		{"escapes", `\{a\|b\} \_\_color\_\_ \\`, []string{`{a|b} __color__ \`}},
+		{"wildcard", "a __color__ cat", []string{"a red cat", "a blue cat"}},
+		{"wildcard in subdirectory", "__styles/light__", []string{"soft light"}},
+		{"nested wildcard", "__animal__", []string{"cat", "red dog", "blue dog"}},
+		{"not a wildcard name", "__two words__ and a__b", []string{"__two words__ and a__b"}},
+		{"path outside directory", "__../color__", []string{"__../color__"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.Expand(tt.template, 100)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", tt.template, err)
+			}
+			if !reflect.DeepEqual(prompts(got), tt.want) {
+				t.Errorf("Expand(%q) = %q, want %q", tt.template, prompts(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandVariables(t *testing.T) {
+	e := newTestExpander(t, map[string]string{"color": "red\n{light|dark} blue\n"})
+
+	got, err := e.Expand("a {big|small} __color__ cat", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("got %d expansions, want 6", len(got))
+	}
+	want := []Variable{
+		{Name: "{big|small}", Value: "small"},
+		{Name: "__color__", Value: "dark blue"},
+		{Name: "{light|dark}", Value: "dark"},
+	}
+	if last := got[len(got)-1]; last.Prompt != "a small dark blue cat" || !reflect.DeepEqual(last.Variables, want) {
+		t.Errorf("last expansion = %+v, want prompt %q with variables %+v", last, "a small dark blue cat", want)
+	}
+}
+
+func TestExpandLimit(t *testing.T) {
+	e := newTestExpander(t, nil)
+
+	if _, err := e.Expand("{a|b}{c|d}", 4); err != nil {
+		t.Errorf("expanding to exactly the limit failed: %v", err)
+	}
+
+	// 2^40 combinations must be rejected without being expanded.
+	template := strings.Repeat("{a|b}", 40)
+	_, err := e.Expand(template, 1000)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != 1000 {
+		t.Errorf("Expand of %d combinations: err = %v, want *LimitError with limit 1000", 1<<40, err)
+	}
+}
+
+func TestExpandWildcardErrors(t *testing.T) {
+	e := newTestExpander(t, map[string]string{
+		"self":  "x\n__self__ again\n",
+		"ping":  "__pong__\n",
+		"pong":  "__ping__\n",
+		"empty": "# nothing but a comment\n\n",
+		"deep0": "__deep1__", "deep1": "__deep2__", "deep2": "__deep3__",
+		"deep3": "__deep4__", "deep4": "__deep5__", "deep5": "__deep6__",
+		"deep6": "__deep7__", "deep7": "bottom",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{"self reference", "__self__", "nested too deeply"},
+		{"mutual reference", "a __ping__", "nested too deeply"},
+		{"unknown", "__missing__", "unknown wildcard __missing__"},
+		{"no options", "__empty__", "wildcard __empty__ has no options"},
+		{"inside alternation", "{a|__self__}", "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := e.Expand(tt.template, 100)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expand(%q) error = %v, want one containing %q", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+
+	// Nesting up to the depth limit is fine.
+	got, err := e.Expand("__deep0__", 100)
+	if err != nil {
+		t.Fatalf("wildcards nested %d deep failed: %v", maxWildcardDepth, err)
+	}
+	if !reflect.DeepEqual(prompts(got), []string{"bottom"}) {
+		t.Errorf("nested wildcards = %q, want [bottom]", prompts(got))
+	}
+}
@@ -0,0 +1,56 @@
+package expand
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseRange parses a sweep of integers: a comma-separated list of values
+// and ranges, where "20-40" includes both ends and "20-40:10" steps by 10.
+// An empty string yields no values. If there would be more than limit
+// values, ParseRange returns a *LimitError.
+func ParseRange(s string, limit int) ([]int64, error) {
+	var values []int64
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		bounds, stepText, hasStep := strings.Cut(item, ":")
+		from, to, isRange := strings.Cut(bounds, "-")
+		if !isRange {
+			to = from
+		}
+		first, err := strconv.ParseInt(strings.TrimSpace(from), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s'", item)
+		}
+		last, err := strconv.ParseInt(strings.TrimSpace(to), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s'", item)
+		}
+		step := int64(1)
+		if hasStep {
+			step, err = strconv.ParseInt(strings.TrimSpace(stepText), 10, 64)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", item)
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("range '%s' ends before it starts", item)
+		}
+
+		for v := first; v <= last; v += step {
+			if len(values) == limit {
+				return nil, &LimitError{Limit: limit}
+			}
+			values = append(values, v)
+			if v > last-step { // Avoid overflowing near the largest int64
+				break
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"imageapi/providers"
+)
+
+// maxImagesPerRequest caps the number of images (n) a single request may ask for.
+const maxImagesPerRequest = 8
+
+// generationRequest is a parsed generation request, independent of the
+// endpoint it arrived on.
+type generationRequest struct {
+	Model          string // Full model name, "provider/model"
+	Style          string // Style preset expanded into the request, see applyStyle
+	Prompt         string
+	NegativePrompt string
+	Width          int
+	Height         int
+	Seed           int64 // Zero picks a random seed
+	Steps          int
+	Guidance       float64
+	Strength       float64
+	LoRAs          []providers.LoRA
+	N              int
+
+	ImageBytes     []byte // Input image; downloaded from ImageURL if empty
+	ImageURL       string
+	ImageFilename  string
+	MaskBytes      []byte // Inpainting mask; downloaded from MaskURL if empty
+	MaskURL        string
+	MaskRects      []MaskRect // Alternative to MaskBytes, in input image coordinates
+	InputSizeLimit uint       // Longest side of the processed input image
+
+	SaveLocalCopy bool // Whether to keep a copy of the results in images/
+}
+
+// generationResult is the outcome of runGeneration.
+type generationResult struct {
+	Images      []resultImage
+	Translation *promptTranslation // Set if the prompt was translated
+	Metadata    generationMetadata
+}
+
+// runGeneration executes a generation request end to end: it validates the
+// request against the model, translates the prompt if the model needs it,
+// prepares the input image (uploading it to the temporary host for models
+// that need a URL), calls the provider and converts the results. The result
+// carries the metadata needed to reproduce it. Errors are *apiError values
+// carrying the HTTP status to report.
+func runGeneration(ctx context.Context, req generationRequest) (*generationResult, error) {
+	start := time.Now()
+	var timings generationTimings
+	if err := applyStyle(&req); err != nil {
+		return nil, err
+	}
+	provider, caps, err := validateGenerationRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	providerName, modelName := provider.GetName(), caps.Name
+
+	// Everything below runs under the caller's context so that upstream calls
+	// stop as soon as the client goes away or the configured deadline passes.
+	ctx, cancel := generationContext(ctx, providerName, modelName)
+	defer cancel()
+
+	translation := translatePrompt(ctx, &req, caps)
+
+	input := providers.GenerationInput{
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Model:          modelName,
+		Width:          req.Width,
+		Height:         req.Height,
+		Seed:           req.Seed,
+		Steps:          req.Steps,
+		Guidance:       req.Guidance,
+		Strength:       req.Strength,
+		LoRAs:          req.LoRAs,
+	}
+	if input.Seed == 0 {
+		input.Seed = rand.Int63n(1000000) // Default seed, max 6 digits
+	}
+	meta := newGenerationMetadata(newGenerationID(), providerName, caps, req, input)
+	log.Printf("Generation %s: model '%s', seed %d, %dx%d", meta.ID, req.Model, input.Seed, input.Width, input.Height)
+
+	// --- Input Image ---
+	imageBytes := req.ImageBytes
+	if len(imageBytes) == 0 && req.ImageURL != "" {
+		log.Printf("Downloading image from provided URL: %s", req.ImageURL)
+		t := time.Now()
+		imageBytes, _, err = providers.DownloadFile(ctx, req.ImageURL)
+		timings.Download += time.Since(t).Milliseconds()
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "Failed to download image from URL: %v", err)
+		}
+	}
+
+	maskBytes := req.MaskBytes
+	if len(maskBytes) == 0 && req.MaskURL != "" {
+		log.Printf("Downloading mask from provided URL: %s", req.MaskURL)
+		t := time.Now()
+		maskBytes, _, err = providers.DownloadFile(ctx, req.MaskURL)
+		timings.Download += time.Since(t).Milliseconds()
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "Failed to download mask from URL: %v", err)
+		}
+	}
+	hasMask := len(maskBytes) > 0 || len(req.MaskRects) > 0
+
+	if len(imageBytes) > 0 {
+		sizeLimit := req.InputSizeLimit
+		if sizeLimit == 0 {
+			sizeLimit = 1024
+		}
+		t := time.Now()
+		processedBytes, err := processImage(imageBytes, sizeLimit)
+		timings.Encode += time.Since(t).Milliseconds()
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, "Failed to process image: %v", err)
+		}
+		input.ImageBytes = processedBytes
+
+		// The mask must line up with the processed image, so it is scaled the same way.
+		if hasMask {
+			t := time.Now()
+			input.MaskBytes, err = prepareMask(imageBytes, processedBytes, maskBytes, req.MaskRects)
+			timings.Encode += time.Since(t).Milliseconds()
+			if err != nil {
+				return nil, newAPIError(http.StatusBadRequest, "Invalid mask: %v", err)
+			}
+		}
+
+		// If the model takes images by URL, upload the image to the host first.
+		if caps.InputTransport == providers.TransportURL {
+			if tempImageHost == nil {
+				return nil, newAPIError(http.StatusInternalServerError, "Image hosting is not configured, cannot process image for this provider")
+			}
+			filename := req.ImageFilename
+			if filename == "" {
+				filename = "input.jpg"
+			}
+			filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg" // processImage always produces JPEG
+
+			log.Println("Model requires an image URL, uploading temporary image...")
+			t := time.Now()
+			uploadResp, err := tempImageHost.Upload(ctx, processedBytes, filename)
+			timings.Upload += time.Since(t).Milliseconds()
+			if err != nil {
+				return nil, newAPIError(http.StatusInternalServerError, "Failed to upload temporary image: %v", err)
+			}
+			input.ImageURL = uploadResp.URL
+			log.Printf("Temporary image uploaded: %s (ID: %s)", input.ImageURL, uploadResp.ID)
+
+			// Delete the temporary image once the provider is done with it,
+			// even if the provider call fails.
+			defer deleteTempImage(uploadResp.ID)
+		}
+	}
+
+	// --- Provider Call ---
+	log.Printf("Calling provider '%s' with model '%s'", providerName, modelName)
+	t := time.Now()
+	generated, err := generateImages(ctx, provider, input, req.N)
+	timings.Provider = time.Since(t).Milliseconds()
+	if err != nil {
+		return nil, newAPIError(providerErrorStatus(err), "Error from provider '%s': %v", providerName, err)
+	}
+
+	// --- Final Images ---
+	t = time.Now()
+	results := make([]resultImage, len(generated))
+	for i, img := range generated {
+		results[i] = prepareResultImage(img, meta, req.SaveLocalCopy)
+	}
+	timings.Encode += time.Since(t).Milliseconds()
+
+	timings.Total = time.Since(start).Milliseconds()
+	meta.Timings = timings
+	return &generationResult{Images: results, Translation: translation, Metadata: meta}, nil
+}
+
+// checkModelInput enforces a model's declared image requirement, input image
+// limit and tasks for a request with the given number of input images.
+func checkModelInput(fullModelName string, caps providers.ModelCapabilities, images int, hasMask bool) error {
+	switch {
+	case images == 0 && caps.ImageInput == providers.ImageRequired:
+		return newAPIError(http.StatusBadRequest, "Model '%s' requires an input image", fullModelName)
+	case images > 0 && !caps.AcceptsImage():
+		return newAPIError(http.StatusBadRequest, "Model '%s' does not accept an input image", fullModelName)
+	case images > caps.MaxInputImages:
+		return newAPIError(http.StatusBadRequest, "Model '%s' accepts at most %d input image(s), got %d", fullModelName, caps.MaxInputImages, images)
+	}
+
+	if hasMask {
+		if images == 0 {
+			return newAPIError(http.StatusBadRequest, "A mask requires an input image")
+		}
+		if !caps.HasTask(providers.TaskInpaint) {
+			return newAPIError(http.StatusBadRequest, "Model '%s' does not support inpainting masks", fullModelName)
+		}
+	} else if images > 0 && !caps.HasTask(providers.TaskEdit) && !caps.HasTask(providers.TaskUpscale) {
+		return newAPIError(http.StatusBadRequest, "Model '%s' requires a mask", fullModelName)
+	}
+	return nil
+}
+
+// prepareMask builds the mask for the processed input image, either from an
+// uploaded mask or from rectangles given in original image coordinates.
+func prepareMask(originalImage, processedImage, maskBytes []byte, rects []MaskRect) ([]byte, error) {
+	processed, _, err := image.DecodeConfig(bytes.NewReader(processedImage))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processed image size: %w", err)
+	}
+	if len(maskBytes) == 0 {
+		original, _, err := image.DecodeConfig(bytes.NewReader(originalImage))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input image size: %w", err)
+		}
+		maskBytes, err = rectangleMask(original.Width, original.Height, rects)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return processMask(maskBytes, processed.Width, processed.Height)
+}
+
+// deleteTempImage removes a temporary input image from the temporary image host.
+func deleteTempImage(id string) {
+	log.Printf("Deleting temporary image with ID: %s", id)
+	// Use a fresh context: the request context may already be canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := tempImageHost.Delete(ctx, id); err != nil {
+		log.Printf("Warning: failed to delete temporary image %s: %v", id, err)
+	}
+}
+
+// resultImage is a final, WebP-converted image ready to be returned to a client.
+type resultImage struct {
+	Bytes     []byte
+	Filename  string
+	LocalPath string
+	Seed      int64
+}
+
+// generateImages produces n images for input. Models that batch natively are
+// called with up to MaxBatch images per request; anything beyond that is
+// fanned out as concurrent requests with sequential seeds (input.Seed,
+// input.Seed+MaxBatch, ...). An image's Seed is the one the provider
+// reported or, for a request of a single image, the seed it was sent with;
+// images of a native batch whose seeds the provider did not report have no
+// Seed. Partial results are returned if only some requests fail.
+func generateImages(ctx context.Context, provider providers.ImageProvider, input providers.GenerationInput, n int) ([]providers.GeneratedImage, error) {
+	if n < 1 {
+		n = 1
+	}
+
+	caps, _ := providers.FindModel(provider, input.Model)
+	maxBatch := caps.MaxBatch
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	seeded := caps.Supports("seed")
+
+	type chunk struct {
+		input  providers.GenerationInput
+		images []providers.GeneratedImage
+		err    error
+	}
+	var chunks []*chunk
+	for offset := 0; offset < n; offset += maxBatch {
+		chunkInput := input
+		chunkInput.BatchSize = min(maxBatch, n-offset)
+		chunkInput.Seed = input.Seed + int64(offset)
+		chunks = append(chunks, &chunk{input: chunkInput})
+	}
+	if len(chunks) > 1 {
+		log.Printf("Fanning out %d images into %d requests to provider '%s'", n, len(chunks), provider.GetName())
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c *chunk) {
+			defer wg.Done()
+			output, err := provider.Generate(ctx, c.input)
+			if err != nil {
+				c.err = err
+				return
+			}
+			for _, img := range output.Images {
+				if len(img.Bytes) == 0 {
+					continue
+				}
+				if img.Seed == 0 && seeded && c.input.BatchSize == 1 {
+					img.Seed = c.input.Seed
+				}
+				c.images = append(c.images, img)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	var images []providers.GeneratedImage
+	var firstErr error
+	for _, c := range chunks {
+		if c.err != nil {
+			if firstErr == nil {
+				firstErr = c.err
+			}
+			log.Printf("Warning: request for seed %d to provider '%s' failed: %v", c.input.Seed, provider.GetName(), c.err)
+			continue
+		}
+		images = append(images, c.images...)
+	}
+
+	if len(images) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("provider did not return any image data")
+	}
+	if len(images) > n {
+		images = images[:n]
+	}
+	return images, nil
+}
+
+// prepareResultImage converts a generated image to WebP, embeds the
+// generation metadata in it and, if save is set, stores a copy in the images
+// directory.
+func prepareResultImage(img providers.GeneratedImage, meta generationMetadata, save bool) resultImage {
+	webpBytes, err := convertToWebP(img.Bytes)
+	if err != nil {
+		// If conversion fails, log the error but proceed with the original image.
+		log.Printf("Warning: failed to convert image to WebP: %v. Using original format.", err)
+		webpBytes = img.Bytes // Fallback to original bytes
+	} else {
+		log.Printf("Successfully converted final image to WebP. Original size: %d, WebP size: %d", len(img.Bytes), len(webpBytes))
+	}
+	webpBytes = embedGenerationMetadata(webpBytes, meta, img.Seed)
+
+	// Generate a filename for potential local saving or content disposition
+	// header. The random ID keeps images saved in the same second apart.
+	id := make([]byte, 8)
+	crand.Read(id) // Never fails, see crypto/rand.Read
+	finalFilename := fmt.Sprintf("%s_%s.webp", time.Now().Format("2006_0102_150405"), hex.EncodeToString(id))
+	localFilepath := fmt.Sprintf("images/%s", finalFilename)
+
+	if save {
+		if err := os.WriteFile(localFilepath, webpBytes, 0644); err != nil {
+			log.Printf("Warning: failed to save final image locally to %s: %v", localFilepath, err)
+		} else {
+			log.Printf("Successfully saved final image to %s", localFilepath)
+		}
+	}
+
+	return resultImage{
+		Bytes:     webpBytes,
+		Filename:  finalFilename,
+		LocalPath: localFilepath,
+		Seed:      img.Seed,
+	}
+}
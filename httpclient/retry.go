@@ -0,0 +1,218 @@
+// Package httpclient builds the HTTP clients used to talk to upstream
+// providers and image hosts.
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"imageapi/config"
+)
+
+// Policy describes how failed requests are retried.
+type Policy struct {
+	MaxRetries int           // Retries after the first attempt; zero disables retrying
+	BaseDelay  time.Duration // Delay before the first retry, doubled for each further one
+	MaxDelay   time.Duration // Upper bound of the backoff delay
+	// Budget bounds the total time spent on a request including retries
+	// and reading the response. Zero means only the request context limits it.
+	Budget time.Duration
+}
+
+// PolicyFor returns the retry policy configured for the named provider or
+// image host, falling back to the global RETRY settings.
+func PolicyFor(name string) Policy {
+	s := config.AppConfig.RetrySettings(name)
+	return Policy{
+		MaxRetries: s.MaxRetries,
+		BaseDelay:  time.Duration(s.BaseDelayMs) * time.Millisecond,
+		MaxDelay:   time.Duration(s.MaxDelayMs) * time.Millisecond,
+		Budget:     time.Duration(s.BudgetSeconds) * time.Second,
+	}
+}
+
+// New returns an HTTP client for the named provider or image host. It uses
+// the shared transport configured for name and retries transient failures
+// according to its retry policy.
+func New(name string) *http.Client {
+	return &http.Client{Transport: NewRetryTransport(name, Transport(name), PolicyFor(name))}
+}
+
+// RetryTransport is an http.RoundTripper that retries the failures retryable
+// accepts with exponential backoff and jitter. A Retry-After header replaces
+// the computed delay, capped at MaxDelay. Every attempt runs under a deadline
+// of the request's start plus the budget, and it gives up early rather than
+// sleep past that deadline or the request's own.
+type RetryTransport struct {
+	name   string
+	base   http.RoundTripper
+	policy Policy
+}
+
+// NewRetryTransport wraps base with policy. name prefixes the log messages.
+func NewRetryTransport(name string, base http.RoundTripper, policy Policy) *RetryTransport {
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 500 * time.Millisecond
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	return &RetryTransport{name: name, base: base, policy: policy}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.Budget <= 0 {
+		return t.roundTrip(req.Context(), req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.policy.Budget)
+	resp, err := t.roundTrip(ctx, req)
+	if resp == nil {
+		cancel()
+		return resp, err
+	}
+	// The deadline must outlive RoundTrip until the body has been read.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, err
+}
+
+// roundTrip sends req under ctx, retrying as the policy allows.
+func (t *RetryTransport) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body for retry: %w", err)
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		// Whether the headers went out, see retryable.
+		var written atomic.Bool
+		trace := &httptrace.ClientTrace{WroteHeaders: func() { written.Store(true) }}
+		resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+		if attempt >= t.policy.MaxRetries || ctx.Err() != nil || !retryable(req, resp, err, written.Load()) {
+			return resp, err
+		}
+		// A streamed body cannot be sent a second time.
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		deadline, hasDeadline := ctx.Deadline()
+		if after, ok := retryAfter(resp); ok {
+			// Retrying before Retry-After is likely futile, so give up if it
+			// lies past the deadline. Otherwise honor it, but never wait
+			// longer than MaxDelay: a request holds a job worker while it waits.
+			if hasDeadline && time.Until(deadline) < after {
+				return resp, err
+			}
+			delay = min(after, t.policy.MaxDelay)
+		}
+		if hasDeadline && time.Until(deadline) < delay {
+			return resp, err
+		}
+
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			// Drain and close the body so that the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		log.Printf("%s: %s %s failed on attempt %d/%d (%s), retrying in %v", t.name, req.Method, req.URL.Redacted(), attempt+1, t.policy.MaxRetries+1, reason, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// cancelBody releases the budget deadline of a request once its response
+// body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryable reports whether a request that ended with resp or err is worth
+// trying again. Requests that are not idempotent, such as the POSTs that
+// submit generation jobs, could create a duplicate job when resent, so they
+// are only retried if they failed before being written or were turned away
+// unprocessed (408, 425, 429 and 503). Everything else, such as a 400 or 401,
+// fails immediately.
+func retryable(req *http.Request, resp *http.Response, err error, written bool) bool {
+	idempotent := isIdempotent(req)
+	if err != nil {
+		// Connection refused, reset, DNS failures and the like
+		return idempotent || !written
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// isIdempotent reports whether sending req twice has the same effect as
+// sending it once. Like net/http, it trusts an Idempotency-Key header.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	_, hasXKey := req.Header["X-Idempotency-Key"]
+	return hasKey || hasXKey
+}
+
+// backoff returns the delay before retry number attempt+1: BaseDelay doubled
+// per attempt, capped at MaxDelay, with the upper half randomized.
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 30 {
+		delay = min(p.BaseDelay<<attempt, p.MaxDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses the Retry-After header of resp, given either in seconds
+// or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
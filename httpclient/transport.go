@@ -0,0 +1,121 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"imageapi/config"
+)
+
+// proxyDirect as PROXY_URL bypasses any proxy, including HTTP(S)_PROXY.
+const proxyDirect = "direct"
+
+// transportKey identifies the transports that can share a connection pool.
+type transportKey struct {
+	proxyURL string
+	caFile   string
+}
+
+// transports caches one *http.Transport per proxy and CA combination, so that
+// providers with the same outbound settings share pooled connections.
+var transports = struct {
+	sync.Mutex
+	byKey map[transportKey]*http.Transport
+}{byKey: make(map[transportKey]*http.Transport)}
+
+// Transport returns the shared transport for the named provider or image host,
+// using its PROXY_URL and CA_FILE from the PROVIDERS section and the global
+// HTTP settings otherwise. A proxy or CA that cannot be used is logged and
+// replaced by the global settings.
+func Transport(name string) *http.Transport {
+	global := config.AppConfig.HTTP
+	key := transportKey{proxyURL: global.ProxyURL, caFile: global.CAFile}
+	if ps, ok := config.AppConfig.Providers[name]; ok {
+		if ps.ProxyURL != "" {
+			key.proxyURL = ps.ProxyURL
+		}
+		if ps.CAFile != "" {
+			key.caFile = ps.CAFile
+		}
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+	if t, ok := transports.byKey[key]; ok {
+		return t
+	}
+
+	t, err := newTransport(key)
+	if err != nil {
+		log.Printf("Warning: invalid HTTP settings for '%s', using the global ones: %v", name, err)
+		key = transportKey{proxyURL: global.ProxyURL, caFile: global.CAFile}
+		if t, ok := transports.byKey[key]; ok {
+			return t
+		}
+		if t, err = newTransport(key); err != nil {
+			log.Printf("Warning: invalid global HTTP settings, using defaults: %v", err)
+			key = transportKey{}
+			t, _ = newTransport(key)
+		}
+	}
+	transports.byKey[key] = t
+	return t
+}
+
+// newTransport creates a transport with the configured timeouts and pool
+// limits, routed through key's proxy and trusting key's CA in addition to the
+// system roots.
+func newTransport(key transportKey) (*http.Transport, error) {
+	settings := config.AppConfig.HTTP
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(settings.DialTimeoutSeconds) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   time.Duration(settings.TLSHandshakeTimeoutSeconds) * time.Second,
+		ResponseHeaderTimeout: time.Duration(settings.ResponseHeaderTimeoutSeconds) * time.Second,
+		IdleConnTimeout:       time.Duration(settings.IdleConnTimeoutSeconds) * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	switch key.proxyURL {
+	case "":
+	case proxyDirect:
+		t.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(key.proxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s'", key.proxyURL)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if key.caFile != "" {
+		pem, err := os.ReadFile(key.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file '%s'", key.caFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return t, nil
+}
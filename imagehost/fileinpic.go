@@ -0,0 +1,156 @@
+package imagehost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"imageapi/httpclient"
+)
+
+const (
+	// DefaultFileInPicBaseURL is the public fileinpic instance.
+	DefaultFileInPicBaseURL = "https://file.oneonezero.dpdns.org"
+
+	fileInPicUploadPath   = "/api/v1/files/upload"
+	fileInPicDownloadPath = "/api/v1/files/public/download/"
+	fileInPicDeletePath   = "/api/v1/files/delete/"
+)
+
+// FileInPicClient handles communication with a fileinpic file host.
+// Image IDs are the numeric file IDs assigned by the server.
+type FileInPicClient struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+// NewFileInPicClient creates a new fileinpic client for the instance at baseURL.
+func NewFileInPicClient(baseURL, apiKey string) *FileInPicClient {
+	if baseURL == "" {
+		baseURL = DefaultFileInPicBaseURL
+	}
+	return &FileInPicClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Client:  httpclient.New("fileinpic"),
+	}
+}
+
+// fileInPicResponse matches the upload and delete responses.
+type fileInPicResponse struct {
+	OK      bool   `json:"ok"`
+	URL     string `json:"url"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// Upload sends the raw image bytes and returns the file ID and absolute download URL.
+func (c *FileInPicClient) Upload(ctx context.Context, imageBytes []byte, filename string) (*UploadResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+fileInPicUploadPath, bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+
+	req.Header.Set("X-API-KEY", c.APIKey)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filename)}))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute upload request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fileinpic API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var uploadResp fileInPicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
+		return nil, fmt.Errorf("failed to decode upload response: %w", err)
+	}
+
+	if !uploadResp.OK || uploadResp.URL == "" {
+		return nil, fmt.Errorf("fileinpic API reported an error: %s", uploadResp.errorMessage())
+	}
+
+	absoluteURL, err := c.resolve(uploadResp.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadResult{
+		ID:  path.Base(strings.TrimRight(uploadResp.URL, "/")),
+		URL: absoluteURL,
+	}, nil
+}
+
+// Delete deletes a file by its ID.
+func (c *FileInPicClient) Delete(ctx context.Context, id string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.BaseURL+fileInPicDeletePath+url.PathEscape(id), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+
+	req.Header.Set("X-API-KEY", c.APIKey)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute delete request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("fileinpic API returned non-200 status for delete: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var deleteResp fileInPicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&deleteResp); err != nil {
+		return fmt.Errorf("failed to decode delete response: %w", err)
+	}
+
+	if !deleteResp.OK {
+		return fmt.Errorf("fileinpic API reported an error on delete: %s", deleteResp.errorMessage())
+	}
+
+	return nil
+}
+
+// PublicURL returns the absolute download URL of a file.
+func (c *FileInPicClient) PublicURL(id string) string {
+	return c.BaseURL + fileInPicDownloadPath + url.PathEscape(id)
+}
+
+// resolve joins a URL returned by the server, which is usually relative,
+// with the configured base URL.
+func (c *FileInPicClient) resolve(ref string) (string, error) {
+	base, err := url.Parse(c.BaseURL + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid fileinpic base URL %q: %w", c.BaseURL, err)
+	}
+	rel, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL in fileinpic response %q: %w", ref, err)
+	}
+	return base.ResolveReference(rel).String(), nil
+}
+
+func (r fileInPicResponse) errorMessage() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	if r.Message != "" {
+		return r.Message
+	}
+	return "no details provided"
+}
@@ -0,0 +1,19 @@
+package imagehost
+
+import "context"
+
+// UploadResult describes an image stored on a host.
+type UploadResult struct {
+	ID  string // Host-specific identifier, used for Delete and PublicURL
+	URL string // Publicly reachable URL of the image
+}
+
+// Host is implemented by every image hosting backend.
+type Host interface {
+	// Upload stores an image and returns its ID and public URL.
+	Upload(ctx context.Context, imageBytes []byte, filename string) (*UploadResult, error)
+	// Delete removes a previously uploaded image by its ID.
+	Delete(ctx context.Context, id string) error
+	// PublicURL returns the public URL of a previously uploaded image.
+	PublicURL(id string) string
+}
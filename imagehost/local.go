@@ -0,0 +1,158 @@
+package imagehost
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalHost stores images in a directory on this server and serves them
+// itself. URLs carry an expiry timestamp and an HMAC signature, so files can
+// be fetched by upstream providers and API clients without exposing the
+// directory publicly.
+type LocalHost struct {
+	Dir     string        // Directory the images are stored in
+	BaseURL string        // Public base URL of this server, e.g. "https://img.example.com"
+	Prefix  string        // Route the host is served under, e.g. "/files/results/"
+	Secret  []byte        // HMAC key used to sign URLs
+	TTL     time.Duration // How long a signed URL stays valid
+}
+
+// NewLocalHost creates a local host serving dir under prefix.
+func NewLocalHost(dir, baseURL, prefix string, secret []byte, ttl time.Duration) (*LocalHost, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local image directory %s: %w", dir, err)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &LocalHost{
+		Dir:     dir,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Prefix:  prefix,
+		Secret:  secret,
+		TTL:     ttl,
+	}, nil
+}
+
+// Upload writes the image into the host directory and returns a signed URL.
+// The ID is the stored filename: a random name with the extension of
+// filename, so concurrent uploads of equally named files never collide.
+func (h *LocalHost) Upload(ctx context.Context, imageBytes []byte, filename string) (*UploadResult, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate image ID: %w", err)
+	}
+	ext := filepath.Ext(filename)
+	if strings.ContainsAny(ext, `/\`) {
+		ext = ""
+	}
+	id := hex.EncodeToString(random) + ext
+	if err := os.WriteFile(filepath.Join(h.Dir, id), imageBytes, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write local image: %w", err)
+	}
+	return &UploadResult{ID: id, URL: h.PublicURL(id)}, nil
+}
+
+// Delete removes an image from the host directory.
+func (h *LocalHost) Delete(ctx context.Context, id string) error {
+	err := os.Remove(filepath.Join(h.Dir, filepath.Base(id)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete local image: %w", err)
+	}
+	return nil
+}
+
+// PublicURL returns a URL for the image that is valid for the host's TTL.
+func (h *LocalHost) PublicURL(id string) string {
+	expires := time.Now().Add(h.TTL).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", h.sign(id, expires))
+	return h.BaseURL + h.Prefix + url.PathEscape(id) + "?" + query.Encode()
+}
+
+// ServeHTTP serves images whose URL signature is valid and not expired.
+func (h *LocalHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, h.Prefix)
+	if id == "" || strings.ContainsAny(id, `/\`) || id == ".." {
+		http.NotFound(w, r)
+		return
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "Link has expired", http.StatusForbidden)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(h.sign(id, expires))) {
+		http.Error(w, "Invalid link signature", http.StatusForbidden)
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(h.Dir, id))
+}
+
+// StartCleanup removes files older than the host's TTL, whose links have all
+// expired, right away and then once per TTL, until ctx is done.
+func (h *LocalHost) StartCleanup(ctx context.Context) {
+	if h.TTL <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(h.TTL)
+		defer ticker.Stop()
+		for {
+			h.removeExpired()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// removeExpired deletes the files in the host directory that were written
+// longer than the TTL ago.
+func (h *LocalHost) removeExpired() {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		log.Printf("Warning: failed to list local images in %s: %v", h.Dir, err)
+		return
+	}
+	cutoff := time.Now().Add(-h.TTL)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.Dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove expired local image %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// sign computes the URL signature for an image ID and expiry time.
+func (h *LocalHost) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, h.Secret)
+	fmt.Fprintf(mac, "%s%s:%d", h.Prefix, id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
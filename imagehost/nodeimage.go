@@ -2,19 +2,27 @@ package imagehost
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"path"
+	"strings"
+
+	"imageapi/httpclient"
 )
 
 const (
 	uploadAPIURL = "https://api.nodeimage.com/api/upload"
 	deleteAPIURL = "https://api.nodeimage.com/api/v1/delete/"
+	cdnBaseURL   = "https://cdn.nodeimage.com/i/"
 )
 
 // NodeImageClient handles communication with the NodeImage API.
+// Image IDs are the stored filenames (e.g. "u5xIj6...rlk.jpeg"), which
+// identify the image for deletion and form its CDN URL.
 type NodeImageClient struct {
 	APIKey string
 	Client *http.Client
@@ -24,16 +32,17 @@ type NodeImageClient struct {
 func NewNodeImageClient(apiKey string) *NodeImageClient {
 	return &NodeImageClient{
 		APIKey: apiKey,
-		Client: &http.Client{},
+		Client: httpclient.New("nodeimage"),
 	}
 }
 
 // UploadResponse matches the structure of the successful upload response.
 type UploadResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	ImageID string `json:"image_id"`
-	Links   struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	ImageID  string `json:"image_id"`
+	Filename string `json:"filename"`
+	Links    struct {
 		Direct string `json:"direct"`
 	} `json:"links"`
 }
@@ -44,8 +53,8 @@ type DeleteResponse struct {
 	Message string `json:"message"`
 }
 
-// UploadImage uploads an image and returns the direct URL and image ID.
-func (c *NodeImageClient) UploadImage(imageBytes []byte, filename string) (*UploadResponse, error) {
+// Upload uploads an image and returns the direct URL and image ID.
+func (c *NodeImageClient) Upload(ctx context.Context, imageBytes []byte, filename string) (*UploadResult, error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 	part, err := writer.CreateFormFile("image", filename)
@@ -58,7 +67,7 @@ func (c *NodeImageClient) UploadImage(imageBytes []byte, filename string) (*Uplo
 	}
 	writer.Close()
 
-	req, err := http.NewRequest("POST", uploadAPIURL, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadAPIURL, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create upload request: %w", err)
 	}
@@ -86,13 +95,19 @@ func (c *NodeImageClient) UploadImage(imageBytes []byte, filename string) (*Uplo
 		return nil, fmt.Errorf("nodeimage API reported an error: %s", uploadResp.Message)
 	}
 
-	return &uploadResp, nil
+	id := uploadResp.Filename
+	if id == "" {
+		id = uploadResp.ImageID
+	}
+	return &UploadResult{ID: id, URL: uploadResp.Links.Direct}, nil
 }
 
-// DeleteImage deletes an image by its ID.
-func (c *NodeImageClient) DeleteImage(imageID string) error {
+// Delete deletes an image by its ID.
+func (c *NodeImageClient) Delete(ctx context.Context, id string) error {
+	// The delete API expects the bare image ID, without the file extension.
+	imageID := strings.TrimSuffix(id, path.Ext(id))
 	deleteURL := deleteAPIURL + imageID
-	req, err := http.NewRequest("DELETE", deleteURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create delete request: %w", err)
 	}
@@ -121,3 +136,8 @@ func (c *NodeImageClient) DeleteImage(imageID string) error {
 
 	return nil
 }
+
+// PublicURL returns the CDN URL of an uploaded image.
+func (c *NodeImageClient) PublicURL(id string) string {
+	return cdnBaseURL + id
+}
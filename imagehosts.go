@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imageapi/config"
+	"imageapi/imagehost"
+)
+
+var (
+	// tempImageHost hosts input images for providers that require an image URL.
+	tempImageHost imagehost.Host
+	// resultImageHost hosts final images returned to clients as URLs.
+	resultImageHost imagehost.Host
+)
+
+// imageHostPurpose identifies what an image host is used for.
+type imageHostPurpose string
+
+const (
+	purposeTempInput imageHostPurpose = "temporary input"
+	purposeResult    imageHostPurpose = "result"
+)
+
+// initializeImageHosts creates the image hosts selected in the IMAGE_HOST config.
+// A host that cannot be created is left nil, which disables the features that depend on it.
+// Local hosts also register the route they serve their files under and start
+// removing expired files.
+func initializeImageHosts() {
+	settings := config.AppConfig.ImageHost
+
+	get := func(purpose imageHostPurpose, name string) imagehost.Host {
+		name = strings.ToLower(name)
+		host, err := newImageHost(name, purpose)
+		if err != nil {
+			log.Printf("Warning: %s image host disabled: %v", purpose, err)
+			return nil
+		}
+		if local, ok := host.(*imagehost.LocalHost); ok {
+			http.Handle(local.Prefix, local)
+			local.StartCleanup(context.Background())
+		}
+		log.Printf("Using image host '%s' for %s images", name, purpose)
+		return host
+	}
+
+	tempImageHost = get(purposeTempInput, settings.TempInput)
+	resultImageHost = get(purposeResult, settings.Result)
+}
+
+// generatedLocalSecret is the random key local hosts share when no secret is
+// configured.
+var generatedLocalSecret []byte
+
+// localHostSecret returns the key local hosts sign URLs with: LOCAL_SECRET,
+// else SESSION_SECRET unless it is the public default, else a random key
+// generated once per process. Signed links then stop working on restart.
+func localHostSecret() ([]byte, error) {
+	if secret := config.AppConfig.ImageHost.LocalSecret; secret != "" {
+		return []byte(secret), nil
+	}
+	if secret := config.AppConfig.Settings.SessionSecret; secret != "" && secret != config.DefaultSessionSecret {
+		return []byte(secret), nil
+	}
+	if generatedLocalSecret == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate URL signing key: %w", err)
+		}
+		log.Println("Warning: neither LOCAL_SECRET nor SESSION_SECRET is set, signing local image URLs with a random key. Links will stop working when the server restarts.")
+		generatedLocalSecret = secret
+	}
+	return generatedLocalSecret, nil
+}
+
+// newImageHost creates the image host backend with the given name.
+func newImageHost(name string, purpose imageHostPurpose) (imagehost.Host, error) {
+	settings := config.AppConfig.ImageHost
+	switch name {
+	case "nodeimage":
+		apiKey := config.AppConfig.APIKeys.NodeImage
+		if apiKey == "" {
+			return nil, fmt.Errorf("NODEIMAGE_API_KEY is not set")
+		}
+		return imagehost.NewNodeImageClient(apiKey), nil
+	case "fileinpic":
+		apiKey := config.AppConfig.APIKeys.FileInPic
+		if apiKey == "" {
+			return nil, fmt.Errorf("FILEINPIC_API_KEY is not set")
+		}
+		return imagehost.NewFileInPicClient(settings.FileInPicBaseURL, apiKey), nil
+	case "local":
+		if settings.LocalBaseURL == "" {
+			return nil, fmt.Errorf("LOCAL_BASE_URL is not set")
+		}
+		secret, err := localHostSecret()
+		if err != nil {
+			return nil, err
+		}
+		ttl := time.Duration(settings.LocalURLTTLMinutes) * time.Minute
+		// Results and temporary inputs each get their own directory, apart
+		// from the local copies in images/, since files are deleted once
+		// their links expire.
+		if purpose == purposeResult {
+			return imagehost.NewLocalHost(settings.LocalResultDir, settings.LocalBaseURL, "/files/results/", secret, ttl)
+		}
+		return imagehost.NewLocalHost(settings.LocalTempDir, settings.LocalBaseURL, "/files/inputs/", secret, ttl)
+	case "", "none":
+		return nil, fmt.Errorf("no image host configured")
+	default:
+		return nil, fmt.Errorf("unknown image host '%s'", name)
+	}
+}
@@ -0,0 +1,155 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+// exifHeader prefixes EXIF data in a JPEG APP1 segment.
+var exifHeader = []byte("Exif\x00\x00")
+
+// TIFF tags and types used in the EXIF data.
+const (
+	tagSoftware    = 0x0131
+	tagExifIFD     = 0x8769
+	tagUserComment = 0x9286
+
+	typeASCII     = 2
+	typeLong      = 4
+	typeUndefined = 7
+)
+
+// userCommentUnicode is the character code of a UTF-16 UserComment.
+var userCommentUnicode = []byte("UNICODE\x00")
+
+// buildEXIF returns big-endian TIFF data holding comment as the EXIF
+// UserComment, encoded as UTF-16 the way the web UIs write it, plus the
+// Software tag.
+func buildEXIF(comment string) []byte {
+	be := binary.BigEndian
+	softwareValue := append([]byte(software), 0)
+	userComment := append([]byte{}, userCommentUnicode...)
+	for _, u := range utf16.Encode([]rune(comment)) {
+		userComment = be.AppendUint16(userComment, u)
+	}
+
+	// Layout: header, IFD0 with two entries, the Software string padded to
+	// an even offset, the EXIF IFD with one entry, the UserComment.
+	const ifd0Offset = 8
+	softwareOffset := ifd0Offset + 2 + 2*12 + 4
+	exifIFDOffset := softwareOffset + len(softwareValue) + len(softwareValue)%2
+	commentOffset := exifIFDOffset + 2 + 12 + 4
+
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = be.AppendUint16(b, tag)
+		b = be.AppendUint16(b, typ)
+		b = be.AppendUint32(b, count)
+		return be.AppendUint32(b, value)
+	}
+
+	b := []byte("MM\x00\x2A")
+	b = be.AppendUint32(b, ifd0Offset)
+	b = be.AppendUint16(b, 2)
+	b = entry(b, tagSoftware, typeASCII, uint32(len(softwareValue)), uint32(softwareOffset))
+	b = entry(b, tagExifIFD, typeLong, 1, uint32(exifIFDOffset))
+	b = be.AppendUint32(b, 0)
+	b = append(b, softwareValue...)
+	if len(softwareValue)%2 == 1 {
+		b = append(b, 0)
+	}
+	b = be.AppendUint16(b, 1)
+	b = entry(b, tagUserComment, typeUndefined, uint32(len(userComment)), uint32(commentOffset))
+	b = be.AppendUint32(b, 0)
+	return append(b, userComment...)
+}
+
+// readUserComment returns the EXIF UserComment of TIFF data.
+func readUserComment(tiff []byte) (string, bool) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("MM\x00\x2A")):
+		order = binary.BigEndian
+	case bytes.HasPrefix(tiff, []byte("II\x2A\x00")):
+		order = binary.LittleEndian
+	default:
+		return "", false
+	}
+
+	if len(tiff) < 8 {
+		return "", false
+	}
+
+	pointer := findTag(tiff, order, order.Uint32(tiff[4:8]), tagExifIFD)
+	if pointer < 0 {
+		return "", false
+	}
+	entry := findTag(tiff, order, order.Uint32(tiff[pointer+8:]), tagUserComment)
+	if entry < 0 {
+		return "", false
+	}
+	count := order.Uint32(tiff[entry+4:])
+	offset := uint32(entry + 8) // Values of up to four bytes are stored in the entry
+	if count > 4 {
+		offset = order.Uint32(tiff[entry+8:])
+	}
+	if count < 8 || uint64(offset)+uint64(count) > uint64(len(tiff)) {
+		return "", false
+	}
+	return decodeUserComment(tiff[offset : offset+count]), true
+}
+
+// findTag returns the position of the entry for tag in the IFD at offset,
+// or -1 if there is none.
+func findTag(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) int {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return -1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		pos := int(offset) + 2 + i*12
+		if pos+12 > len(tiff) {
+			return -1
+		}
+		if order.Uint16(tiff[pos:]) == tag {
+			return pos
+		}
+	}
+	return -1
+}
+
+// decodeUserComment decodes a UserComment from its 8-byte character code.
+// Writers disagree on the byte order of UTF-16 comments, so the order that
+// yields more ASCII characters wins.
+func decodeUserComment(comment []byte) string {
+	code, text := comment[:8], comment[8:]
+	if !bytes.Equal(code, userCommentUnicode) {
+		// ASCII, or undefined which in practice means UTF-8.
+		return strings.ToValidUTF8(string(bytes.TrimRight(text, "\x00")), "\uFFFD")
+	}
+
+	if len(text)%2 == 1 {
+		text = text[:len(text)-1]
+	}
+	bigEndianASCII := 0
+	for i := 0; i < len(text); i += 2 {
+		if text[i] == 0 && text[i+1] != 0 {
+			bigEndianASCII++
+		} else if text[i] != 0 && text[i+1] == 0 {
+			bigEndianASCII--
+		}
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if bigEndianASCII < 0 {
+		order = binary.LittleEndian
+	}
+	units := make([]uint16, 0, len(text)/2)
+	for i := 0; i < len(text); i += 2 {
+		units = append(units, order.Uint16(text[i:]))
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,103 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// userComment encodes text as a UserComment with the given character code,
+// using order for UTF-16.
+func userComment(code string, order binary.AppendByteOrder, text string) []byte {
+	b := append([]byte(code), make([]byte, 8-len(code))...)
+	if code != "UNICODE" {
+		return append(b, text...)
+	}
+	for _, u := range utf16.Encode([]rune(text)) {
+		b = order.AppendUint16(b, u)
+	}
+	return b
+}
+
+func TestDecodeUserComment(t *testing.T) {
+	const parameters = "a cat\nSteps: 20, Seed: 1234"
+	tests := []struct {
+		name    string
+		comment []byte
+		want    string
+	}{
+		{"UTF-16 big-endian", userComment("UNICODE", binary.BigEndian, parameters), parameters},
+		{"UTF-16 little-endian", userComment("UNICODE", binary.LittleEndian, parameters), parameters},
+		{"UTF-16 big-endian, not ASCII", userComment("UNICODE", binary.BigEndian, "猫, Steps: 20"), "猫, Steps: 20"},
+		{"UTF-16 little-endian, not ASCII", userComment("UNICODE", binary.LittleEndian, "猫, Steps: 20"), "猫, Steps: 20"},
+		{"UTF-16 with trailing zeros", append(userComment("UNICODE", binary.BigEndian, "a cat"), 0, 0, 0, 0), "a cat"},
+		{"UTF-16 odd length", append(userComment("UNICODE", binary.LittleEndian, "a cat"), 'x'), "a cat"},
+		{"ASCII", userComment("ASCII", nil, parameters+"\x00"), parameters},
+		{"undefined code holding UTF-8", userComment("", nil, "一只猫"), "一只猫"},
+		{"empty", userComment("UNICODE", binary.BigEndian, ""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeUserComment(tt.comment); got != tt.want {
+				t.Errorf("decodeUserComment = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// littleEndianEXIF returns little-endian TIFF data with comment as the
+// UserComment, laid out differently from buildEXIF: no Software tag, and the
+// EXIF IFD holds a second entry before the UserComment.
+func littleEndianEXIF(comment []byte) []byte {
+	le := binary.LittleEndian
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		return le.AppendUint32(b, value)
+	}
+	const exifIFDOffset = 8 + 2 + 12 + 4
+	const commentOffset = exifIFDOffset + 2 + 2*12 + 4
+
+	b := []byte("II\x2A\x00")
+	b = le.AppendUint32(b, 8)
+	b = le.AppendUint16(b, 1)
+	b = entry(b, tagExifIFD, typeLong, 1, exifIFDOffset)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint16(b, 2)
+	b = entry(b, 0x9000, typeUndefined, 4, 0x30333230) // ExifVersion "0230", stored inline
+	b = entry(b, tagUserComment, typeUndefined, uint32(len(comment)), commentOffset)
+	b = le.AppendUint32(b, 0)
+	return append(b, comment...)
+}
+
+func TestReadUserComment(t *testing.T) {
+	const parameters = "a cat\nSteps: 20, Seed: 1234"
+	tests := []struct {
+		name string
+		tiff []byte
+	}{
+		{"buildEXIF", buildEXIF(parameters)},
+		{"little-endian TIFF, little-endian comment", littleEndianEXIF(userComment("UNICODE", binary.LittleEndian, parameters))},
+		{"little-endian TIFF, big-endian comment", littleEndianEXIF(userComment("UNICODE", binary.BigEndian, parameters))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := readUserComment(tt.tiff)
+			if !ok || got != parameters {
+				t.Errorf("readUserComment = %q, %t, want %q", got, ok, parameters)
+			}
+
+			// Truncated data must be rejected, not read out of bounds.
+			for n := range len(tt.tiff) {
+				if got, ok := readUserComment(tt.tiff[:n]); ok && got == parameters {
+					t.Errorf("data cut to %d bytes still read in full", n)
+				}
+			}
+		})
+	}
+
+	if _, ok := readUserComment([]byte("not TIFF data")); ok {
+		t.Error("readUserComment accepted data without a TIFF header")
+	}
+}
@@ -0,0 +1,118 @@
+// Package imagemeta embeds generation metadata in image files and reads it
+// back. The metadata is stored where common readers look for it: as a
+// "parameters" text chunk in PNG, as an EXIF UserComment in JPEG and WebP,
+// and in all three formats as an XMP packet that also carries the full
+// settings as JSON.
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chai2010/webp"
+)
+
+// ErrUnsupportedFormat is returned for data that is not a PNG, JPEG or WebP image.
+var ErrUnsupportedFormat = errors.New("unsupported image format, expected PNG, JPEG or WebP")
+
+// Format names returned by Read.
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+// Metadata is what gets embedded in an image.
+type Metadata struct {
+	Parameters string          // Text in the parameters format, see Parameters
+	Details    json.RawMessage // Full settings as JSON, stored in the XMP packet; may be empty
+}
+
+// software names the writer in the EXIF data.
+const software = "imageapi"
+
+// Embed returns a copy of the image data with m embedded, replacing metadata
+// a previous Embed wrote.
+func Embed(data []byte, m Metadata) ([]byte, error) {
+	xmp := buildXMP(m)
+	switch detectFormat(data) {
+	case FormatPNG:
+		return embedPNG(data, m.Parameters, xmp)
+	case FormatJPEG:
+		return embedJPEG(data, buildEXIF(m.Parameters), xmp)
+	case FormatWebP:
+		out, err := webp.SetMetadata(data, buildEXIF(m.Parameters), "EXIF")
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed EXIF in WebP: %w", err)
+		}
+		out, err = webp.SetMetadata(out, xmp, "XMP")
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed XMP in WebP: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// Read returns the format of the image data and the metadata embedded in it.
+// Parameters written by other tools are found too. An image without metadata
+// yields an empty Metadata and no error.
+func Read(data []byte) (string, Metadata, error) {
+	format := detectFormat(data)
+	var parameters, details string
+	var err error
+	switch format {
+	case FormatPNG:
+		parameters, details, err = readPNG(data)
+	case FormatJPEG:
+		parameters, details, err = readJPEG(data)
+	case FormatWebP:
+		parameters, details = readWebP(data)
+	default:
+		return "", Metadata{}, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return format, Metadata{}, err
+	}
+
+	m := Metadata{Parameters: parameters}
+	if details != "" && json.Valid([]byte(details)) {
+		m.Details = json.RawMessage(details)
+	}
+	return format, m, nil
+}
+
+// detectFormat identifies the image format from its signature.
+func detectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return FormatWebP
+	default:
+		return ""
+	}
+}
+
+// readWebP reads the EXIF and XMP chunks of a WebP image. The libwebp mux
+// reports missing chunks as errors, so errors simply mean no metadata.
+func readWebP(data []byte) (parameters, details string) {
+	if exif, err := webp.GetMetadata(data, "EXIF"); err == nil {
+		// The EXIF chunk should hold bare TIFF data, but some writers keep
+		// the JPEG APP1 prefix.
+		parameters, _ = readUserComment(bytes.TrimPrefix(exif, exifHeader))
+	}
+	if xmp, err := webp.GetMetadata(data, "XMP"); err == nil {
+		xmpParameters, xmpDetails := parseXMP(xmp)
+		if parameters == "" {
+			parameters = xmpParameters
+		}
+		details = xmpDetails
+	}
+	return parameters, details
+}
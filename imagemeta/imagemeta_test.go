@@ -0,0 +1,188 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// testImage returns a small gradient, so that encoders produce real image data.
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// encodePNG, encodeJPEG and encodeWebP encode testImage in each format.
+func encodePNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeWebP(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, testImage(), &webp.Options{Quality: 80}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decode checks that data is still a valid image of the given format.
+func decode(t *testing.T, format string, data []byte) {
+	t.Helper()
+	var err error
+	switch format {
+	case FormatPNG:
+		_, err = png.Decode(bytes.NewReader(data))
+	case FormatJPEG:
+		_, err = jpeg.Decode(bytes.NewReader(data))
+	case FormatWebP:
+		_, err = webp.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		t.Errorf("image no longer decodes after embedding: %v", err)
+	}
+}
+
+func TestEmbedRead(t *testing.T) {
+	images := []struct {
+		format string
+		encode func(*testing.T) []byte
+	}{
+		{FormatPNG, encodePNG},
+		{FormatJPEG, encodeJPEG},
+		{FormatWebP, encodeWebP},
+	}
+	metadata := []struct {
+		name string
+		m    Metadata
+	}{
+		{"parameters only", Metadata{Parameters: "a cat\nSteps: 20, Seed: 1, Size: 16x16"}},
+		{"with details", Metadata{
+			Parameters: "a cat\nNegative prompt: blurry\nSteps: 20, Seed: 1, Size: 16x16",
+			Details:    json.RawMessage(`{"prompt":"a cat","seed":1,"note":"<&>"}`),
+		}},
+		{"Latin-1", Metadata{Parameters: "un café crème\nSteps: 20, Seed: 1, Size: 16x16"}},
+		{"beyond Latin-1", Metadata{
+			Parameters: "一只穿着宇航服的猫 🐱\nSteps: 20, Seed: 1, Size: 16x16",
+			Details:    json.RawMessage(`{"prompt":"一只穿着宇航服的猫 🐱"}`),
+		}},
+	}
+
+	for _, img := range images {
+		for _, md := range metadata {
+			t.Run(img.format+"/"+md.name, func(t *testing.T) {
+				data := img.encode(t)
+				embedded, err := Embed(data, md.m)
+				if err != nil {
+					t.Fatalf("Embed failed: %v", err)
+				}
+				decode(t, img.format, embedded)
+
+				format, got, err := Read(embedded)
+				if err != nil {
+					t.Fatalf("Read failed: %v", err)
+				}
+				if format != img.format {
+					t.Errorf("format = %q, want %q", format, img.format)
+				}
+				if got.Parameters != md.m.Parameters {
+					t.Errorf("parameters = %q, want %q", got.Parameters, md.m.Parameters)
+				}
+				if !bytes.Equal(got.Details, md.m.Details) {
+					t.Errorf("details = %s, want %s", got.Details, md.m.Details)
+				}
+
+				// Embedding again replaces the metadata instead of adding to it.
+				again, err := Embed(embedded, md.m)
+				if err != nil {
+					t.Fatalf("second Embed failed: %v", err)
+				}
+				if len(again) != len(embedded) {
+					t.Errorf("embedding twice gives %d bytes, once %d", len(again), len(embedded))
+				}
+			})
+		}
+	}
+}
+
+func TestReadWithoutMetadata(t *testing.T) {
+	for _, data := range [][]byte{encodePNG(t), encodeJPEG(t), encodeWebP(t)} {
+		format, m, err := Read(data)
+		if err != nil {
+			t.Errorf("Read of a plain %s failed: %v", format, err)
+		}
+		if m.Parameters != "" || m.Details != nil {
+			t.Errorf("plain %s has metadata %+v", format, m)
+		}
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("GIF89a"), []byte("RIFF\x00\x00\x00\x00WAVE")} {
+		if _, _, err := Read(data); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Read(%q) error = %v, want ErrUnsupportedFormat", data, err)
+		}
+		if _, err := Embed(data, Metadata{Parameters: "a cat"}); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Embed(%q) error = %v, want ErrUnsupportedFormat", data, err)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	m := Metadata{Parameters: "a cat\nSteps: 20, Seed: 1, Size: 16x16", Details: json.RawMessage(`{"seed":1}`)}
+	embedded := map[string][]byte{}
+	for _, encode := range []func(*testing.T) []byte{encodePNG, encodeJPEG, encodeWebP} {
+		data, err := Embed(encode(t), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		embedded[detectFormat(data)] = data
+	}
+
+	// Every prefix must be handled without a panic, and parameters are
+	// either read in full or not at all.
+	for format, data := range embedded {
+		for n := len(pngSignature); n < len(data); n++ {
+			if _, got, _ := Read(data[:n]); got.Parameters != "" && got.Parameters != m.Parameters {
+				t.Errorf("%s cut to %d bytes has parameters %q", format, n, got.Parameters)
+			}
+		}
+	}
+
+	// A cut inside the metadata segment is an error, as is embedding into it.
+	for _, format := range []string{FormatPNG, FormatJPEG} {
+		cut := embedded[format][:40]
+		if _, _, err := Read(cut); err == nil {
+			t.Errorf("Read of a truncated %s succeeded", format)
+		}
+		if _, err := Embed(cut, m); err == nil {
+			t.Errorf("Embed into a truncated %s succeeded", format)
+		}
+	}
+}
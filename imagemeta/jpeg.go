@@ -0,0 +1,121 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// xmpHeader prefixes the XMP packet in a JPEG APP1 segment.
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// JPEG markers used below.
+const (
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerSOS  = 0xDA
+)
+
+// maxSegmentData is the largest payload of a JPEG segment, whose length
+// field is 16 bits and counts itself.
+const maxSegmentData = 0xFFFF - 2
+
+var errTruncatedJPEG = errors.New("truncated JPEG segment")
+
+// jpegSegment is a marker segment of a JPEG file, up to the start of scan.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// readJPEGSegments splits JPEG data into the segments before the start of
+// scan and the remaining data, which starts with the SOS marker.
+func readJPEGSegments(data []byte) ([]jpegSegment, []byte, error) {
+	var segments []jpegSegment
+	pos := 2 // After SOI
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, nil, errTruncatedJPEG
+		}
+		marker := data[pos+1]
+		if marker == 0xFF { // Fill byte
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[pos:], nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, errTruncatedJPEG
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+}
+
+// appendJPEGSegment appends a segment with its marker and length.
+func appendJPEGSegment(b []byte, s jpegSegment) []byte {
+	b = append(b, 0xFF, s.marker)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s.data)+2))
+	return append(b, s.data...)
+}
+
+// embedJPEG stores the EXIF data and the XMP packet in APP1 segments after
+// the JFIF header, replacing any existing ones.
+func embedJPEG(data, exif, xmp []byte) ([]byte, error) {
+	exifSegment := append(append([]byte{}, exifHeader...), exif...)
+	xmpSegment := append(append([]byte{}, xmpHeader...), xmp...)
+	if len(exifSegment) > maxSegmentData || len(xmpSegment) > maxSegmentData {
+		return nil, fmt.Errorf("metadata exceeds the %d bytes of a JPEG segment", maxSegmentData)
+	}
+
+	segments, scan, err := readJPEGSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data)+len(exifSegment)+len(xmpSegment)+8)
+	out = append(out, 0xFF, 0xD8)
+	inserted := false
+	for _, s := range segments {
+		if s.marker == markerAPP1 && (bytes.HasPrefix(s.data, exifHeader) || bytes.HasPrefix(s.data, xmpHeader)) {
+			continue // Replaced
+		}
+		if !inserted && s.marker != markerAPP0 {
+			out = appendJPEGSegment(out, jpegSegment{marker: markerAPP1, data: exifSegment})
+			out = appendJPEGSegment(out, jpegSegment{marker: markerAPP1, data: xmpSegment})
+			inserted = true
+		}
+		out = appendJPEGSegment(out, s)
+	}
+	if !inserted {
+		out = appendJPEGSegment(out, jpegSegment{marker: markerAPP1, data: exifSegment})
+		out = appendJPEGSegment(out, jpegSegment{marker: markerAPP1, data: xmpSegment})
+	}
+	return append(out, scan...), nil
+}
+
+// readJPEG returns the parameters and XMP details of JPEG data.
+func readJPEG(data []byte) (parameters, details string, err error) {
+	segments, _, err := readJPEGSegments(data)
+	if err != nil {
+		return "", "", err
+	}
+	var xmpParameters string
+	for _, s := range segments {
+		if s.marker != markerAPP1 {
+			continue
+		}
+		if tiff, ok := bytes.CutPrefix(s.data, exifHeader); ok {
+			parameters, _ = readUserComment(tiff)
+		} else if xmp, ok := bytes.CutPrefix(s.data, xmpHeader); ok {
+			xmpParameters, details = parseXMP(xmp)
+		}
+	}
+	if parameters == "" {
+		parameters = xmpParameters
+	}
+	return parameters, details, nil
+}
@@ -0,0 +1,108 @@
+package imagemeta
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Parameters is a generation description in the "parameters" text format of
+// the Stable Diffusion web UIs, which most image viewers and metadata readers
+// understand:
+//
+//	a cat in a space suit
+//	Negative prompt: blurry, lowres
+//	Steps: 28, CFG scale: 7, Seed: 1234, Size: 1024x1024, Model: Flux
+type Parameters struct {
+	Prompt         string
+	NegativePrompt string
+	Settings       []Setting // The last line, in order
+}
+
+// Setting is one "Key: value" pair of Parameters.
+type Setting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+const negativePromptPrefix = "Negative prompt:"
+
+// settingPattern matches one setting of the last line. Values containing
+// commas or colons are quoted as JSON strings.
+var settingPattern = regexp.MustCompile(`\s*([\w ]+):\s*("(?:\\.|[^\\"])+"|[^,]*)(?:,|$)`)
+
+// String formats p in the parameters format.
+func (p Parameters) String() string {
+	var b strings.Builder
+	b.WriteString(p.Prompt)
+	if p.NegativePrompt != "" {
+		b.WriteString("\n" + negativePromptPrefix + " " + p.NegativePrompt)
+	}
+	if len(p.Settings) > 0 {
+		settings := make([]string, len(p.Settings))
+		for i, s := range p.Settings {
+			settings[i] = s.Key + ": " + quoteValue(s.Value)
+		}
+		b.WriteString("\n" + strings.Join(settings, ", "))
+	}
+	return b.String()
+}
+
+// Get returns the value of the setting key.
+func (p Parameters) Get(key string) (string, bool) {
+	for _, s := range p.Settings {
+		if s.Key == key {
+			return s.Value, true
+		}
+	}
+	return "", false
+}
+
+// quoteValue quotes a value that would otherwise break the settings line.
+func quoteValue(v string) string {
+	if !strings.ContainsAny(v, ",:\"\n") {
+		return v
+	}
+	quoted, _ := json.Marshal(v)
+	return string(quoted)
+}
+
+// ParseParameters parses text in the parameters format. The last line is
+// only taken as settings if it consists of at least three settings, as the
+// web UIs do, so a plain prompt parses as just a prompt.
+func ParseParameters(text string) Parameters {
+	var p Parameters
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if last := lines[len(lines)-1]; len(lines) > 1 || strings.Count(last, ":") >= 3 {
+		if matches := settingPattern.FindAllStringSubmatch(last, -1); len(matches) >= 3 {
+			for _, m := range matches {
+				value := strings.TrimSpace(m[2])
+				if strings.HasPrefix(value, `"`) {
+					var unquoted string
+					if json.Unmarshal([]byte(value), &unquoted) == nil {
+						value = unquoted
+					}
+				}
+				p.Settings = append(p.Settings, Setting{Key: strings.TrimSpace(m[1]), Value: value})
+			}
+			lines = lines[:len(lines)-1]
+		}
+	}
+
+	var prompt, negative []string
+	inNegative := false
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), negativePromptPrefix); ok {
+			inNegative = true
+			line = strings.TrimSpace(rest)
+		}
+		if inNegative {
+			negative = append(negative, line)
+		} else {
+			prompt = append(prompt, line)
+		}
+	}
+	p.Prompt = strings.Join(prompt, "\n")
+	p.NegativePrompt = strings.Join(negative, "\n")
+	return p
+}
@@ -0,0 +1,119 @@
+package imagemeta
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParametersRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Parameters
+	}{
+		{"prompt only", Parameters{Prompt: "a cat in a space suit"}},
+		{"multi-line prompt", Parameters{Prompt: "a cat\nin a space suit"}},
+		{"prompt with colons", Parameters{
+			Prompt:   "style: anime, mood: calm, light: soft",
+			Settings: []Setting{{"Steps", "20"}, {"Seed", "1"}, {"Size", "16x16"}},
+		}},
+		{"full", Parameters{
+			Prompt:         "a cat in a space suit",
+			NegativePrompt: "blurry, lowres",
+			Settings: []Setting{
+				{"Steps", "28"}, {"CFG scale", "7.5"}, {"Seed", "1234"}, {"Size", "1024x768"}, {"Model", "Flux"},
+			},
+		}},
+		{"multi-line negative prompt", Parameters{
+			Prompt:         "a cat",
+			NegativePrompt: "blurry\nlowres",
+			Settings:       []Setting{{"Steps", "20"}, {"Seed", "1"}, {"Size", "16x16"}},
+		}},
+		{"no prompt", Parameters{
+			NegativePrompt: "blurry",
+			Settings:       []Setting{{"Steps", "20"}, {"Seed", "1"}, {"Size", "16x16"}},
+		}},
+		{"quoted values", Parameters{
+			Prompt: "a cat",
+			Settings: []Setting{
+				{"Steps", "20"},
+				{"LoRAs", "org/style-lora:0.8, org/detail:1"},
+				{"Model", `say "hi"`},
+				{"Note", "two\nlines"},
+				{"Path", `C:\models\flux`},
+			},
+		}},
+		{"Unicode", Parameters{
+			Prompt:         "一只穿着宇航服的猫",
+			NegativePrompt: "模糊",
+			Settings:       []Setting{{"Steps", "20"}, {"Style", "水彩"}, {"Size", "16x16"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.p.String()
+			if got := ParseParameters(text); !reflect.DeepEqual(got, tt.p) {
+				t.Errorf("ParseParameters(%q) = %+v, want %+v", text, got, tt.p)
+			}
+		})
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Parameters
+	}{
+		{
+			"fewer than three settings are part of the prompt",
+			"a cat\nSteps: 20, Seed: 1",
+			Parameters{Prompt: "a cat\nSteps: 20, Seed: 1"},
+		},
+		{
+			"a lone line of three settings is settings, as in the web UIs",
+			"style: anime, mood: calm, light: soft",
+			Parameters{Settings: []Setting{{"style", "anime"}, {"mood", "calm"}, {"light", "soft"}}},
+		},
+		{
+			"surrounding whitespace",
+			"\n  a cat\nNegative prompt:   blurry  \nSteps: 20,  Seed: 1 ,Size: 16x16\n",
+			Parameters{
+				Prompt:         "a cat",
+				NegativePrompt: "blurry",
+				Settings:       []Setting{{"Steps", "20"}, {"Seed", "1"}, {"Size", "16x16"}},
+			},
+		},
+		{
+			"web UI output",
+			`masterpiece, 1girl
+Negative prompt: lowres, bad anatomy
+Steps: 30, Sampler: DPM++ 2M Karras, CFG scale: 7, Seed: 42, Size: 512x768, Lora hashes: "detail: 0123abcd"`,
+			Parameters{
+				Prompt:         "masterpiece, 1girl",
+				NegativePrompt: "lowres, bad anatomy",
+				Settings: []Setting{
+					{"Steps", "30"}, {"Sampler", "DPM++ 2M Karras"}, {"CFG scale", "7"},
+					{"Seed", "42"}, {"Size", "512x768"}, {"Lora hashes", "detail: 0123abcd"},
+				},
+			},
+		},
+		{"empty", "", Parameters{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseParameters(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseParameters(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParametersGet(t *testing.T) {
+	p := Parameters{Settings: []Setting{{"Steps", "20"}, {"Seed", "1"}}}
+	if v, ok := p.Get("Seed"); !ok || v != "1" {
+		t.Errorf(`Get("Seed") = %q, %t, want "1", true`, v, ok)
+	}
+	if _, ok := p.Get("Model"); ok {
+		t.Error(`Get("Model") found a setting that is not there`)
+	}
+}
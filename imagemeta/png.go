@@ -0,0 +1,191 @@
+package imagemeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG text keywords: the web UIs' parameters and the XMP packet.
+const (
+	keywordParameters = "parameters"
+	keywordXMP        = "XML:com.adobe.xmp"
+)
+
+var errTruncatedPNG = errors.New("truncated PNG chunk")
+
+// pngChunk is a chunk of a PNG file.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// readPNGChunks splits PNG data into its chunks, without the signature.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	var chunks []pngChunk
+	rest := data[len(pngSignature):]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, errTruncatedPNG
+		}
+		length := binary.BigEndian.Uint32(rest)
+		if uint64(length)+12 > uint64(len(rest)) {
+			return nil, errTruncatedPNG
+		}
+		chunks = append(chunks, pngChunk{typ: string(rest[4:8]), data: rest[8 : 8+length]})
+		rest = rest[12+length:]
+	}
+	return chunks, nil
+}
+
+// appendPNGChunk appends a chunk with its length and CRC.
+func appendPNGChunk(b []byte, c pngChunk) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(c.data)))
+	start := len(b)
+	b = append(b, c.typ...)
+	b = append(b, c.data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start:]))
+}
+
+// embedPNG stores the parameters as a tEXt chunk, or as an iTXt chunk if
+// they do not fit Latin-1, and the XMP packet as an iTXt chunk, right after
+// the header so readers find them without scanning the image data.
+func embedPNG(data []byte, parameters string, xmp []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return nil, errors.New("PNG does not start with a header chunk")
+	}
+
+	out := make([]byte, 0, len(data)+len(parameters)+len(xmp)+64)
+	out = append(out, pngSignature...)
+	for i, c := range chunks {
+		if keyword, _, ok := textChunk(c); ok && (keyword == keywordParameters || keyword == keywordXMP) {
+			continue // Replaced below
+		}
+		out = appendPNGChunk(out, c)
+		if i == 0 {
+			if latin1, ok := toLatin1(parameters); ok {
+				out = appendPNGChunk(out, pngChunk{typ: "tEXt", data: append([]byte(keywordParameters+"\x00"), latin1...)})
+			} else {
+				out = appendPNGChunk(out, iTXtChunk(keywordParameters, []byte(parameters)))
+			}
+			out = appendPNGChunk(out, iTXtChunk(keywordXMP, xmp))
+		}
+	}
+	return out, nil
+}
+
+// iTXtChunk returns an uncompressed iTXt chunk without language tags.
+func iTXtChunk(keyword string, text []byte) pngChunk {
+	data := append([]byte(keyword), 0, 0, 0, 0, 0)
+	return pngChunk{typ: "iTXt", data: append(data, text...)}
+}
+
+// readPNG returns the parameters and XMP details of PNG data.
+func readPNG(data []byte) (parameters, details string, err error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return "", "", err
+	}
+	for _, c := range chunks {
+		keyword, text, ok := textChunk(c)
+		switch {
+		case !ok:
+		case keyword == keywordParameters:
+			parameters = text
+		case keyword == keywordXMP:
+			xmpParameters, xmpDetails := parseXMP([]byte(text))
+			if parameters == "" {
+				parameters = xmpParameters
+			}
+			details = xmpDetails
+		}
+	}
+	return parameters, details, nil
+}
+
+// textChunk decodes a tEXt, zTXt or iTXt chunk into its keyword and text.
+func textChunk(c pngChunk) (keyword, text string, ok bool) {
+	keywordBytes, rest, found := bytes.Cut(c.data, []byte{0})
+	if !found {
+		return "", "", false
+	}
+	keyword = string(keywordBytes)
+
+	switch c.typ {
+	case "tEXt":
+		return keyword, fromLatin1(rest), true
+	case "zTXt":
+		if len(rest) < 1 {
+			return "", "", false
+		}
+		inflated, err := inflate(rest[1:])
+		if err != nil {
+			return "", "", false
+		}
+		return keyword, fromLatin1(inflated), true
+	case "iTXt":
+		// Compression flag and method, then language tag and translated
+		// keyword, each terminated by a zero byte.
+		if len(rest) < 2 {
+			return "", "", false
+		}
+		compressed := rest[0] == 1
+		rest = rest[2:]
+		for range 2 {
+			if _, rest, found = bytes.Cut(rest, []byte{0}); !found {
+				return "", "", false
+			}
+		}
+		if compressed {
+			inflated, err := inflate(rest)
+			if err != nil {
+				return "", "", false
+			}
+			rest = inflated
+		}
+		return keyword, strings.ToValidUTF8(string(rest), "\uFFFD"), true
+	default:
+		return "", "", false
+	}
+}
+
+// inflate decompresses zlib data.
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// toLatin1 encodes s as Latin-1, as tEXt chunks require.
+func toLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+// fromLatin1 decodes Latin-1 text.
+func fromLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
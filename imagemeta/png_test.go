@@ -0,0 +1,115 @@
+package imagemeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+)
+
+// deflate compresses data with zlib, as zTXt and compressed iTXt chunks store it.
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withChunks returns PNG data with the given chunks inserted after the header.
+func withChunks(t *testing.T, data []byte, extra ...pngChunk) []byte {
+	t.Helper()
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte{}, pngSignature...)
+	out = appendPNGChunk(out, chunks[0])
+	for _, c := range extra {
+		out = appendPNGChunk(out, c)
+	}
+	for _, c := range chunks[1:] {
+		out = appendPNGChunk(out, c)
+	}
+	return out
+}
+
+func TestReadPNGTextChunks(t *testing.T) {
+	const parameters = "a cat\nSteps: 20, Seed: 1234, Size: 16x16"
+	const unicodeParameters = "一只猫\nSteps: 20, Seed: 1234, Size: 16x16"
+	latin1 := func(s string) []byte {
+		b, ok := toLatin1(s)
+		if !ok {
+			t.Fatalf("%q does not fit Latin-1", s)
+		}
+		return b
+	}
+	xmp := buildXMP(Metadata{Parameters: parameters, Details: []byte(`{"seed":1234}`)})
+
+	tests := []struct {
+		name        string
+		chunks      []pngChunk
+		want        string
+		wantDetails string
+	}{
+		{"tEXt", []pngChunk{{"tEXt", append([]byte("parameters\x00"), parameters...)}}, parameters, ""},
+		{"tEXt Latin-1", []pngChunk{{"tEXt", append([]byte("parameters\x00"), latin1("café crème")...)}}, "café crème", ""},
+		{"zTXt", []pngChunk{{"zTXt", append([]byte("parameters\x00\x00"), deflate(t, latin1("café, "+parameters))...)}}, "café, " + parameters, ""},
+		{"iTXt", []pngChunk{iTXtChunk(keywordParameters, []byte(unicodeParameters))}, unicodeParameters, ""},
+		{"compressed iTXt with language tag", []pngChunk{{"iTXt", append([]byte("parameters\x00\x01\x00zh\x00参数\x00"), deflate(t, []byte(unicodeParameters))...)}}, unicodeParameters, ""},
+		{"XMP only", []pngChunk{iTXtChunk(keywordXMP, xmp)}, parameters, `{"seed":1234}`},
+		{"parameters chunk wins over XMP", []pngChunk{
+			{"tEXt", append([]byte("parameters\x00"), "from tEXt"...)},
+			iTXtChunk(keywordXMP, xmp),
+		}, "from tEXt", `{"seed":1234}`},
+		{"other keywords", []pngChunk{{"tEXt", append([]byte("Comment\x00"), parameters...)}}, "", ""},
+		{"corrupt zTXt", []pngChunk{{"zTXt", []byte("parameters\x00\x00not zlib data")}}, "", ""},
+		{"iTXt without translated keyword", []pngChunk{{"iTXt", []byte("parameters\x00\x00\x00en")}}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := withChunks(t, encodePNG(t), tt.chunks...)
+			format, m, err := Read(data)
+			if err != nil || format != FormatPNG {
+				t.Fatalf("Read = %q, %v", format, err)
+			}
+			if m.Parameters != tt.want {
+				t.Errorf("parameters = %q, want %q", m.Parameters, tt.want)
+			}
+			if string(m.Details) != tt.wantDetails {
+				t.Errorf("details = %s, want %s", m.Details, tt.wantDetails)
+			}
+		})
+	}
+}
+
+func TestEmbedPNGReplacesTextChunks(t *testing.T) {
+	// Parameters written by another tool, compressed, are replaced rather
+	// than left next to the new ones.
+	data := withChunks(t, encodePNG(t), pngChunk{"zTXt", append([]byte("parameters\x00\x00"), deflate(t, []byte("old"))...)})
+	embedded, err := Embed(data, Metadata{Parameters: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keywords []string
+	for _, c := range chunks {
+		if keyword, _, ok := textChunk(c); ok {
+			keywords = append(keywords, c.typ+" "+keyword)
+		}
+	}
+	want := []string{"tEXt " + keywordParameters, "iTXt " + keywordXMP}
+	if len(keywords) != len(want) || keywords[0] != want[0] || keywords[1] != want[1] {
+		t.Errorf("text chunks = %q, want %q", keywords, want)
+	}
+	if chunks[0].typ != "IHDR" {
+		t.Errorf("first chunk = %s, want IHDR", chunks[0].typ)
+	}
+}
@@ -0,0 +1,57 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// xmpNamespace is the namespace of the details property in XMP packets.
+const xmpNamespace = "urn:imageapi:xmp:1.0#"
+
+// buildXMP returns an XMP packet holding the parameters as the image
+// description and the details as a property of its own.
+func buildXMP(m Metadata) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:imageapi="` + xmpNamespace + `">` + "\n")
+	b.WriteString(`<xmp:CreatorTool>` + software + `</xmp:CreatorTool>` + "\n")
+	b.WriteString(`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">`)
+	xml.EscapeText(&b, []byte(m.Parameters))
+	b.WriteString("</rdf:li></rdf:Alt></dc:description>\n")
+	if len(m.Details) > 0 {
+		b.WriteString("<imageapi:details>")
+		xml.EscapeText(&b, m.Details)
+		b.WriteString("</imageapi:details>\n")
+	}
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+	return b.Bytes()
+}
+
+// xmpPacket is the part of an XMP packet parseXMP reads. Elements are
+// matched by local name, so the prefixes a writer chose do not matter.
+type xmpPacket struct {
+	Descriptions []struct {
+		Description []string `xml:"description>Alt>li"`
+		Details     string   `xml:"details"`
+	} `xml:"RDF>Description"`
+}
+
+// parseXMP returns the image description and the details of an XMP packet.
+func parseXMP(data []byte) (parameters, details string) {
+	var packet xmpPacket
+	if err := xml.Unmarshal(data, &packet); err != nil {
+		return "", ""
+	}
+	for _, d := range packet.Descriptions {
+		if parameters == "" && len(d.Description) > 0 {
+			parameters = d.Description[0]
+		}
+		if details == "" {
+			details = d.Details
+		}
+	}
+	return parameters, details
+}
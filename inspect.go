@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"imageapi/imagemeta"
+)
+
+// embedGenerationMetadata writes the settings that produced an image into
+// the image file: the parameters text for common readers and, alongside,
+// the metadata JSON. The file holds a single image, so if its own seed is
+// known the metadata is narrowed to that seed and n = 1, which reproduces
+// exactly that image; otherwise it keeps the request's seed and n, which
+// reproduce the batch the image is part of. If embedding fails the image is
+// returned unchanged.
+func embedGenerationMetadata(data []byte, meta generationMetadata, seed int64) []byte {
+	if seed != 0 || meta.Seed == 0 {
+		meta.N = 1
+	}
+	if seed != 0 && meta.Seed != 0 {
+		meta.Seed = seed
+	}
+
+	// Timings describe the request rather than the image, and are not
+	// final while the image is being encoded.
+	details, err := json.Marshal(struct {
+		generationMetadata
+		Timings *generationTimings `json:"timings,omitempty"`
+	}{generationMetadata: meta})
+	if err != nil {
+		log.Printf("Warning: failed to encode metadata for image file: %v", err)
+		return data
+	}
+
+	embedded, err := imagemeta.Embed(data, imagemeta.Metadata{
+		Parameters: imageParameters(meta).String(),
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to embed metadata in image file: %v", err)
+		return data
+	}
+	return embedded
+}
+
+// imageParameters describes a generation in the parameters format of the
+// Stable Diffusion web UIs, using their setting names where there is one.
+func imageParameters(meta generationMetadata) imagemeta.Parameters {
+	p := imagemeta.Parameters{Prompt: meta.Prompt, NegativePrompt: meta.NegativePrompt}
+	add := func(key, value string) {
+		p.Settings = append(p.Settings, imagemeta.Setting{Key: key, Value: value})
+	}
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+	if meta.Steps > 0 {
+		add("Steps", strconv.Itoa(meta.Steps))
+	}
+	if meta.Guidance > 0 {
+		add("CFG scale", formatFloat(meta.Guidance))
+	}
+	if meta.Seed != 0 {
+		add("Seed", strconv.FormatInt(meta.Seed, 10))
+	}
+	add("Size", fmt.Sprintf("%dx%d", meta.Width, meta.Height))
+	add("Model", meta.Model)
+	if meta.Strength > 0 {
+		add("Denoising strength", formatFloat(meta.Strength))
+	}
+	if len(meta.LoRAs) > 0 {
+		loras := make([]string, len(meta.LoRAs))
+		for i, lora := range meta.LoRAs {
+			weight := lora.Weight
+			if weight == 0 {
+				weight = 1
+			}
+			loras[i] = lora.Name + ":" + formatFloat(weight)
+		}
+		add("LoRAs", strings.Join(loras, ", "))
+	}
+	if meta.AppliedStyle != "" {
+		add("Style", meta.AppliedStyle)
+	}
+	add("Provider", meta.Provider)
+	add("Generation ID", meta.ID)
+	return p
+}
+
+// maxInspectUploadSize limits the size of images sent to /api/v1/inspect.
+const maxInspectUploadSize = 32 << 20
+
+// APIInspectResponse is the metadata read from an image. Parameters written
+// by other tools are returned too, in which case Metadata is usually absent.
+type APIInspectResponse struct {
+	Format         string              `json:"format"` // "png", "jpeg" or "webp"
+	Found          bool                `json:"found"`  // Whether the image carries any parameters
+	Parameters     string              `json:"parameters,omitempty"`
+	Prompt         string              `json:"prompt,omitempty"`
+	NegativePrompt string              `json:"negative_prompt,omitempty"`
+	Settings       []imagemeta.Setting `json:"settings,omitempty"` // The parameters' settings line, in order
+	Metadata       json.RawMessage     `json:"metadata,omitempty"` // The generation metadata embedded by this service, without timings
+}
+
+// handleAPIInspect handles POST /api/v1/inspect, which reads the generation
+// metadata embedded in an image. The image is sent either as the "image"
+// field of a multipart form or as the raw request body.
+func handleAPIInspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "Only POST method is allowed"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInspectUploadSize)
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxInspectUploadSize); err != nil {
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "Invalid multipart form: %v", err))
+			return
+		}
+		if data, _, err = readFormFile(r, "image"); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	} else {
+		if data, err = io.ReadAll(r.Body); err != nil {
+			writeAPIError(w, newAPIError(http.StatusBadRequest, "Failed to read request body: %v", err))
+			return
+		}
+	}
+	if len(data) == 0 {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "'image' is required"))
+		return
+	}
+
+	format, m, err := imagemeta.Read(data)
+	if errors.Is(err, imagemeta.ErrUnsupportedFormat) {
+		writeAPIError(w, newAPIError(http.StatusUnsupportedMediaType, "Unsupported image format, expected PNG, JPEG or WebP"))
+		return
+	}
+	if err != nil {
+		writeAPIError(w, newAPIError(http.StatusBadRequest, "Failed to read image metadata: %v", err))
+		return
+	}
+
+	resp := APIInspectResponse{Format: format, Found: m.Parameters != "", Parameters: m.Parameters, Metadata: m.Details}
+	if m.Parameters != "" {
+		p := imagemeta.ParseParameters(m.Parameters)
+		resp.Prompt = p.Prompt
+		resp.NegativePrompt = p.NegativePrompt
+		resp.Settings = p.Settings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
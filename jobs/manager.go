@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a job ID is unknown.
+	ErrNotFound = errors.New("job not found")
+	// ErrQueueFull is returned by Submit when no more jobs can be queued.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrFinished is returned by Cancel when the job has already finished.
+	ErrFinished = errors.New("job has already finished")
+)
+
+// RunFunc executes a job request and returns its JSON result.
+// It must return promptly once ctx is done.
+type RunFunc func(ctx context.Context, request json.RawMessage) (json.RawMessage, error)
+
+// Manager runs jobs from a Store on a bounded pool of workers.
+type Manager struct {
+	store     *Store
+	run       RunFunc
+	workers   int
+	queue     chan string
+	retention time.Duration
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// NewManager creates a manager with the given number of workers and queue capacity.
+// Finished jobs older than retention are pruned; zero keeps them forever.
+func NewManager(store *Store, run RunFunc, workers, queueSize int, retention time.Duration) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &Manager{
+		store:     store,
+		run:       run,
+		workers:   workers,
+		queue:     make(chan string, queueSize),
+		retention: retention,
+		cancels:   make(map[string]context.CancelFunc),
+	}
+}
+
+// Start launches the workers and re-queues any jobs that were queued or
+// running when the server last stopped.
+func (m *Manager) Start() {
+	for i := 0; i < m.workers; i++ {
+		go m.worker()
+	}
+
+	m.prune()
+
+	var pending []string
+	for _, job := range m.store.List() {
+		if job.Status.Finished() {
+			continue
+		}
+		if job.Status == StatusRunning {
+			if _, err := m.store.Update(job.ID, func(j *Job) {
+				j.Status = StatusQueued
+				j.StartedAt = nil
+			}); err != nil {
+				log.Printf("Jobs: failed to reset interrupted job %s: %v", job.ID, err)
+				continue
+			}
+		}
+		pending = append(pending, job.ID)
+	}
+	if len(pending) > 0 {
+		log.Printf("Jobs: resuming %d unfinished job(s) from previous run", len(pending))
+		// The queue may be smaller than the backlog, so feed it in the background.
+		go func() {
+			for _, id := range pending {
+				m.queue <- id
+			}
+		}()
+	}
+}
+
+// Submit stores a new queued job for request and schedules it.
+func (m *Manager) Submit(request json.RawMessage) (*Job, error) {
+	m.prune()
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Status:    StatusQueued,
+		Request:   request,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.store.Put(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m.queue <- id:
+		return job, nil
+	default:
+		if err := m.store.Delete(id); err != nil {
+			log.Printf("Jobs: failed to remove rejected job %s: %v", id, err)
+		}
+		return nil, ErrQueueFull
+	}
+}
+
+// Get returns the current state of a job.
+func (m *Manager) Get(id string) (*Job, error) {
+	job, ok := m.store.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// Cancel stops a queued or running job.
+func (m *Manager) Cancel(id string) (*Job, error) {
+	job, ok := m.store.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status.Finished() {
+		return job, ErrFinished
+	}
+
+	// Holding mu orders the status change against process, so a job is
+	// either canceled before it starts or its cancel func is registered.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var finished bool // The job finished since it was read above
+	job, err := m.store.Update(id, func(j *Job) {
+		if j.Status.Finished() {
+			finished = true
+			return
+		}
+		now := time.Now()
+		j.Status = StatusCanceled
+		j.Error = "canceled by client"
+		j.FinishedAt = &now
+	})
+	if finished && err == nil {
+		return job, ErrFinished
+	}
+	if cancel, running := m.cancels[id]; running {
+		// The worker records the final status once the run function returns.
+		cancel()
+	}
+	return job, err
+}
+
+func (m *Manager) worker() {
+	for id := range m.queue {
+		m.process(id)
+	}
+}
+
+func (m *Manager) process(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The status is checked and changed in one update, so a job canceled
+	// while waiting in the queue never starts.
+	m.mu.Lock()
+	started := false
+	job, err := m.store.Update(id, func(j *Job) {
+		if j.Status != StatusQueued {
+			return
+		}
+		now := time.Now()
+		j.Status = StatusRunning
+		j.StartedAt = &now
+		started = true
+	})
+	if started && err == nil {
+		m.cancels[id] = cancel
+	}
+	m.mu.Unlock()
+	if !started {
+		// Deleted or canceled while waiting in the queue.
+		return
+	}
+	if err != nil {
+		log.Printf("Jobs: failed to mark job %s as running: %v", id, err)
+		return
+	}
+	defer func() {
+		m.mu.Lock()
+		delete(m.cancels, id)
+		m.mu.Unlock()
+	}()
+
+	log.Printf("Jobs: running job %s", id)
+	result, runErr := m.run(ctx, job.Request)
+
+	if _, err := m.store.Update(id, func(j *Job) {
+		if j.Status == StatusCanceled {
+			return
+		}
+		now := time.Now()
+		j.FinishedAt = &now
+		if runErr != nil {
+			j.Status = StatusFailed
+			j.Error = runErr.Error()
+			return
+		}
+		j.Status = StatusSucceeded
+		j.Result = result
+	}); err != nil {
+		log.Printf("Jobs: failed to record result of job %s: %v", id, err)
+		return
+	}
+	if runErr != nil {
+		log.Printf("Jobs: job %s failed: %v", id, runErr)
+	} else {
+		log.Printf("Jobs: job %s succeeded", id)
+	}
+}
+
+func (m *Manager) prune() {
+	if m.retention <= 0 {
+		return
+	}
+	if err := m.store.Prune(time.Now().Add(-m.retention)); err != nil {
+		log.Printf("Jobs: failed to prune old jobs: %v", err)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("jobs: failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,177 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status describes where a job is in its lifecycle.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether the status is terminal.
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// Job is a single unit of asynchronous work and its outcome.
+// Request and Result are opaque JSON documents owned by the caller.
+type Job struct {
+	ID         string          `json:"id"`
+	Status     Status          `json:"status"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Store is a small embedded job store backed by a single JSON file.
+// Every mutation rewrites the file atomically, so the store survives restarts.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// OpenStore loads the store at path, creating its directory if needed.
+// A missing file yields an empty store.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("jobs: failed to create store directory: %w", err)
+	}
+
+	s := &Store{path: path, jobs: make(map[string]*Job)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("jobs: failed to read store: %w", err)
+	}
+
+	var list []*Job
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("jobs: failed to decode store %s: %w", path, err)
+	}
+	for _, job := range list {
+		s.jobs[job.ID] = job
+	}
+	return s, nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (s *Store) Get(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	c := *job
+	return &c, true
+}
+
+// List returns copies of all jobs, oldest first.
+func (s *Store) List() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		c := *job
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Put inserts or replaces a job and persists the store.
+func (s *Store) Put(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *job
+	s.jobs[job.ID] = &c
+	return s.saveLocked()
+}
+
+// Update applies fn to the stored job and persists the store.
+// It returns a copy of the updated job.
+func (s *Store) Update(id string, fn func(job *Job)) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("jobs: job %s not found", id)
+	}
+	fn(job)
+	job.UpdatedAt = time.Now()
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	c := *job
+	return &c, nil
+}
+
+// Delete removes a job and persists the store.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return s.saveLocked()
+}
+
+// Prune removes finished jobs last updated before cutoff.
+func (s *Store) Prune(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for id, job := range s.jobs {
+		if job.Status.Finished() && job.UpdatedAt.Before(cutoff) {
+			delete(s.jobs, id)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// saveLocked writes the store to a temporary file and renames it into place.
+// The caller must hold s.mu.
+func (s *Store) saveLocked() error {
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("jobs: failed to encode store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("jobs: failed to write store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("jobs: failed to replace store: %w", err)
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -13,12 +16,10 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"sort"
 	"time"
 
 	"imageapi/config"
-	"imageapi/imagehost"
 	"imageapi/middleware"
 	"imageapi/providers"
 
@@ -28,7 +29,6 @@ import (
 
 var (
 	providerRegistry map[string]providers.ImageProvider
-	imageHostClient  *imagehost.NodeImageClient
 )
 
 func main() {
@@ -43,18 +43,24 @@ func main() {
 	// Initialize the session store
 	middleware.InitSessionStore()
 
-	// Initialize the image host client
-	nodeImageAPIKey := config.AppConfig.APIKeys.NodeImage
-	if nodeImageAPIKey == "" {
-		log.Println("Warning: NODEIMAGE_API_KEY is not set. Image hosting will be disabled.")
-	}
-	imageHostClient = imagehost.NewNodeImageClient(nodeImageAPIKey)
+	// Initialize the image hosts
+	initializeImageHosts()
+
+	// Initialize the prompt optimizer backends and the prompt translator
+	initializePromptOptimizers()
+	initializeTranslator()
+
+	// Load the style presets
+	initializeStyles()
 
 	// Ensure images directory exists
 	if err := os.MkdirAll("images", 0755); err != nil {
 		log.Fatalf("Could not create images directory: %v", err)
 	}
 
+	// Start the asynchronous job workers
+	initializeJobs()
+
 	// Serve static files
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
@@ -69,15 +75,31 @@ func main() {
 
 	// Handle the API requests
 	http.HandleFunc("/api/generate", handleGenerate)
+	http.HandleFunc("/api/batch", handleBatch)
 	http.HandleFunc("/api/models", handleGetModels)
 	http.HandleFunc("/api/optimize-prompt", handleOptimizePrompt)
+	http.HandleFunc("/api/styles", handleGetStyles)
 
 	// External v1 API routes, protected by API Key
 	apiV1 := http.NewServeMux()
 	apiV1.HandleFunc("/api/v1/models", handleAPIGetModels)
 	apiV1.HandleFunc("/api/v1/generate", handleAPIGenerate)
+	apiV1.HandleFunc("/api/v1/batch", handleAPIBatch)
+	apiV1.HandleFunc("/api/v1/jobs", handleAPIJobs)
+	apiV1.HandleFunc("/api/v1/jobs/", handleAPIJob)
+	apiV1.HandleFunc("/api/v1/providers/status", handleAPIProviderStatus)
+	apiV1.HandleFunc("/api/v1/optimize-prompt", handleAPIOptimizePrompt)
+	apiV1.HandleFunc("/api/v1/styles", handleAPIGetStyles)
+	apiV1.HandleFunc("/api/v1/inspect", handleAPIInspect)
 	http.Handle("/api/v1/", middleware.APIKeyAuthMiddleware(apiV1))
 
+	// OpenAI-compatible Images API, protected by the same API Key
+	openAI := http.NewServeMux()
+	openAI.HandleFunc("/v1/models", handleOpenAIModels)
+	openAI.HandleFunc("/v1/images/generations", handleOpenAIImageGenerations)
+	openAI.HandleFunc("/v1/images/edits", handleOpenAIImageEdits)
+	http.Handle("/v1/", middleware.APIKeyAuthMiddleware(openAI))
+
 	log.Println("Starting server on :37375...")
 	if err := http.ListenAndServe(":37375", nil); err != nil {
 		log.Fatalf("Could not start server: %s\n", err)
@@ -93,7 +115,7 @@ func initializeProviders() {
 
 	// Fal.ai
 	if config.AppConfig.APIKeys.FalAI != "" {
-		falAI := providers.NewFalAIProvider(config.AppConfig.APIKeys.FalAI)
+		falAI := providers.NewFalAIProvider(config.AppConfig.APIKeys.FalAI, falModelsFromConfig(config.AppConfig.FalModels))
 		providerRegistry[falAI.GetName()] = falAI
 	} else {
 		log.Println("Warning: FAL_API_KEY not set, Fal.ai provider disabled.")
@@ -125,7 +147,111 @@ func initializeProviders() {
 		log.Println("Warning: CLOUDFLARE_ACCOUNT_ID or CLOUDFLARE_API_TOKEN not set, Cloudflare provider disabled.")
 	}
 
+	// OpenAI-compatible backends declared in conf.json
+	for _, settings := range config.AppConfig.OpenAICompatible {
+		if settings.Name == "" || settings.BaseURL == "" {
+			log.Println("Warning: skipping OPENAI_COMPATIBLE entry without NAME or BASE_URL.")
+			continue
+		}
+		if _, exists := providerRegistry[settings.Name]; exists {
+			log.Printf("Warning: OPENAI_COMPATIBLE provider '%s' conflicts with an existing provider, skipping.", settings.Name)
+			continue
+		}
+		openAI := providers.NewOpenAICompatibleProvider(settings.Name, settings.BaseURL, settings.APIKey, settings.ResponseFormat, modelCapabilitiesFromConfig(settings.Models))
+		providerRegistry[openAI.GetName()] = openAI
+	}
+
+	// Declarative HTTP providers declared in conf.json
+	for _, settings := range config.AppConfig.HTTPProviders {
+		if settings.Name == "" {
+			log.Println("Warning: skipping HTTP_PROVIDERS entry without NAME.")
+			continue
+		}
+		if _, exists := providerRegistry[settings.Name]; exists {
+			log.Printf("Warning: HTTP_PROVIDERS provider '%s' conflicts with an existing provider, skipping.", settings.Name)
+			continue
+		}
+		provider, err := providers.NewHTTPTemplateProvider(settings, modelCapabilitiesFromConfig(settings.Models))
+		if err != nil {
+			log.Printf("Warning: HTTP_PROVIDERS provider '%s' is invalid, skipping: %v", settings.Name, err)
+			continue
+		}
+		providerRegistry[provider.GetName()] = provider
+	}
+
 	log.Printf("Initialized %d providers", len(providerRegistry))
+
+	// Refresh the model lists of providers with an upstream catalog in the background.
+	if ttl := config.AppConfig.Settings.ModelCatalogTTLMinutes; ttl > 0 {
+		registered := make([]providers.ImageProvider, 0, len(providerRegistry))
+		for _, provider := range providerRegistry {
+			registered = append(registered, provider)
+		}
+		providers.StartModelDiscovery(context.Background(), registered, time.Duration(ttl)*time.Minute)
+	} else {
+		log.Println("Info: MODEL_CATALOG_TTL_MINUTES is 0, model discovery disabled.")
+	}
+
+	// Track the health of every provider and fail fast while one keeps failing.
+	health := config.AppConfig.Health
+	breaker := providers.BreakerSettings{
+		FailureThreshold: health.FailureThreshold,
+		OpenDuration:     time.Duration(health.OpenSeconds) * time.Second,
+		HalfOpenProbes:   health.HalfOpenProbes,
+		WindowSize:       health.WindowSize,
+	}
+	for name, provider := range providerRegistry {
+		providerRegistry[name] = providers.NewHealthTrackedProvider(provider, breaker)
+	}
+}
+
+// modelCapabilitiesFromConfig converts model declarations from conf.json into provider capabilities.
+func modelCapabilitiesFromConfig(models []config.ModelSettings) []providers.ModelCapabilities {
+	caps := make([]providers.ModelCapabilities, len(models))
+	for i, m := range models {
+		caps[i] = providers.ModelCapabilities{
+			Name:            m.Name,
+			SupportedParams: m.SupportedParams,
+			MaxWidth:        m.MaxWidth,
+			MaxHeight:       m.MaxHeight,
+			MinSteps:        m.MinSteps,
+			MaxSteps:        m.MaxSteps,
+			DefaultSteps:    m.DefaultSteps,
+			MaxBatch:        m.MaxBatch,
+			SizeMultiple:    m.SizeMultiple,
+			ImageInput:      providers.ImageRequirement(m.ImageInput),
+			MaxInputImages:  m.MaxInputImages,
+			InputTransport:  providers.ImageTransport(m.InputTransport),
+			EnglishPrompt:   m.EnglishPrompt,
+		}
+		for _, task := range m.Tasks {
+			caps[i].Tasks = append(caps[i].Tasks, providers.Task(task))
+		}
+		if len(m.ParamRanges) > 0 {
+			caps[i].ParamRanges = make(map[string]providers.ParamRange, len(m.ParamRanges))
+			for name, r := range m.ParamRanges {
+				caps[i].ParamRanges[name] = providers.ParamRange{Min: r.Min, Max: r.Max, Default: r.Default}
+			}
+		}
+		caps[i] = caps[i].Normalize()
+	}
+	return caps
+}
+
+// falModelsFromConfig converts the FAL_MODELS declarations from conf.json.
+func falModelsFromConfig(models []config.FalModelSettings) []providers.FalModel {
+	falModels := make([]providers.FalModel, len(models))
+	for i, m := range models {
+		falModels[i] = providers.FalModel{
+			Capabilities:  modelCapabilitiesFromConfig([]config.ModelSettings{m.ModelSettings})[0],
+			Endpoint:      m.Endpoint,
+			ImageSize:     m.ImageSize,
+			ImageField:    m.ImageField,
+			SafetyChecker: m.SafetyChecker,
+			ExtraParams:   m.ExtraParams,
+		}
+	}
+	return falModels
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
@@ -197,10 +323,20 @@ type ModelDetail struct {
 	MinSteps        int      `json:"min_steps,omitempty"`
 	MaxSteps        int      `json:"max_steps,omitempty"`
 	DefaultSteps    int      `json:"default_steps,omitempty"`
+	MaxBatch        int      `json:"max_batch,omitempty"`
+	SizeMultiple    int      `json:"size_multiple,omitempty"`
+
+	ParamRanges map[string]providers.ParamRange `json:"param_ranges,omitempty"`
+
+	Tasks          []providers.Task           `json:"tasks"`
+	ImageInput     providers.ImageRequirement `json:"image_input"`
+	MaxInputImages int                        `json:"max_input_images"`
+	InputTransport providers.ImageTransport   `json:"input_transport,omitempty"`
 }
 
 type ProviderInfo struct {
 	Provider string        `json:"provider"`
+	Status   string        `json:"status"` // healthy, degraded or unavailable
 	Models   []ModelDetail `json:"models"`
 }
 
@@ -209,10 +345,11 @@ func handleGetModels(w http.ResponseWriter, r *http.Request) {
 
 	// Iterate over the registered providers to dynamically build the response.
 	for name, provider := range providerRegistry {
-		modelsFromProvider := provider.GetModels()
+		modelsFromProvider := providers.Models(provider)
 		modelsForAPI := make([]ModelDetail, len(modelsFromProvider))
 
 		for i, m := range modelsFromProvider {
+			m = m.Normalize()
 			modelsForAPI[i] = ModelDetail{
 				Name:            fmt.Sprintf("%s/%s", name, m.Name),
 				SupportedParams: m.SupportedParams,
@@ -221,13 +358,24 @@ func handleGetModels(w http.ResponseWriter, r *http.Request) {
 				MinSteps:        m.MinSteps,
 				MaxSteps:        m.MaxSteps,
 				DefaultSteps:    m.DefaultSteps,
+				MaxBatch:        m.MaxBatch,
+				SizeMultiple:    m.SizeMultiple,
+				ParamRanges:     m.ParamRanges,
+				Tasks:           m.Tasks,
+				ImageInput:      m.ImageInput,
+				MaxInputImages:  m.MaxInputImages,
+				InputTransport:  m.InputTransport,
 			}
 		}
 
 		providerInfo := ProviderInfo{
 			Provider: name,
+			Status:   providers.HealthHealthy,
 			Models:   modelsForAPI,
 		}
+		if tracked, ok := provider.(*providers.HealthTrackedProvider); ok {
+			providerInfo.Status = tracked.Status().Status
+		}
 		availableProviders = append(availableProviders, providerInfo)
 	}
 
@@ -235,231 +383,187 @@ func handleGetModels(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(availableProviders)
 }
 
-func handleGenerate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+// parseWebGenerationRequest reads the multipart form of the web UI, including
+// uploaded image and mask files. Errors are *apiError values.
+func parseWebGenerationRequest(r *http.Request) (generationRequest, error) {
 	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB
-		http.Error(w, "Could not parse multipart form", http.StatusBadRequest)
-		return
-	}
-
-	// --- 1. Parse and Validate Input ---
-	fullModelName := r.FormValue("model")
-	providerName, modelName, err := providers.ParseModelName(fullModelName)
+		return generationRequest{}, newAPIError(http.StatusBadRequest, "Could not parse multipart form")
+	}
+
+	// --- Form Fields ---
+	genReq := generationRequest{
+		Model:          r.FormValue("model"),
+		Style:          r.FormValue("style"),
+		Prompt:         r.FormValue("prompt"),
+		NegativePrompt: r.FormValue("negative_prompt"),
+		ImageURL:       r.FormValue("imageUrl"),
+		ImageFilename:  "image.png", // Default filename
+		N:              1,
+		SaveLocalCopy:  config.AppConfig.Settings.SaveLocalCopy,
+	}
+
+	// Malformed numbers are rejected with a field error instead of being
+	// silently ignored.
+	form := &formParser{r: r}
+	form.Int("width", &genReq.Width)
+	form.Int("height", &genReq.Height)
+	form.Int("steps", &genReq.Steps)
+	form.Int64("seed", &genReq.Seed)
+	form.Float("guidance", &genReq.Guidance)
+	form.Float("strength", &genReq.Strength)
+	form.LoRAs("loras", &genReq.LoRAs)
+	form.Int("n", &genReq.N)
+	var inputSizeLimit int
+	form.Int("input_size_limit", &inputSizeLimit)
+	if inputSizeLimit > 0 {
+		genReq.InputSizeLimit = uint(inputSizeLimit)
+	}
+	rects, err := parseMaskRects(r.FormValue("mask_rects"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	provider, ok := providerRegistry[providerName]
-	if !ok {
-		http.Error(w, fmt.Sprintf("Provider '%s' not found or not configured", providerName), http.StatusBadRequest)
-		return
-	}
-
-	width, _ := strconv.Atoi(r.FormValue("width"))
-	height, _ := strconv.Atoi(r.FormValue("height"))
-	if width == 0 {
-		width = 1024
-	}
-	if height == 0 {
-		height = 1024
-	}
-
-	input := providers.GenerationInput{
-		Prompt: r.FormValue("prompt"),
-		Model:  modelName,
-		Width:  width,
-		Height: height,
-		Seed:   rand.Int63n(1000000), // Default seed, max 6 digits
+		form.errors = append(form.errors, FieldError{Field: "mask_rects", Message: err.Error()})
 	}
-
-	// Parse optional parameters
-	if stepsStr := r.FormValue("steps"); stepsStr != "" {
-		if steps, err := strconv.Atoi(stepsStr); err == nil {
-			input.Steps = steps
-		}
+	genReq.MaskRects = rects
+	if err := form.Err(); err != nil {
+		return generationRequest{}, err
 	}
-	if seedStr := r.FormValue("seed"); seedStr != "" {
-		if seed, err := strconv.ParseInt(seedStr, 10, 64); err == nil {
-			input.Seed = seed
-		}
-	}
-
-	// --- 2. Handle Image Input ---
-	var tempImageID string // To store the ID of a temporarily uploaded image
-	var providedImageBytes []byte
-	var providedImageFilename string = "image.png" // Default filename
 
-	// Try to get image from file upload first
+	// --- Image and Mask Files ---
+	// An uploaded file takes precedence over the corresponding URL.
 	file, handler, err := r.FormFile("image")
 	if err != nil && err != http.ErrMissingFile {
-		http.Error(w, "Could not retrieve image from form", http.StatusBadRequest)
-		return
+		return generationRequest{}, newAPIError(http.StatusBadRequest, "Could not retrieve image from form")
 	}
-
 	if err == nil { // Image file was provided
 		defer file.Close()
-		providedImageBytes, _ = io.ReadAll(file)
-		providedImageFilename = handler.Filename
-	} else {
-		// If no file, check for an image URL
-		imageURL := r.FormValue("imageUrl")
-		if imageURL != "" {
-			log.Printf("Downloading image from provided URL: %s", imageURL)
-			// Use the new shared DownloadFile function
-			downloadedBytes, _, err := providers.DownloadFile(imageURL) // We don't need the content type here
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Failed to download image from URL: %v", err), http.StatusBadRequest)
-				return
-			}
-			providedImageBytes = downloadedBytes
-		}
+		genReq.ImageBytes, _ = io.ReadAll(file)
+		genReq.ImageFilename = handler.Filename
 	}
 
-	if len(providedImageBytes) > 0 {
-		// --- 2a. Process Input Image (Resize and Compress) ---
-		inputSizeLimit, _ := strconv.Atoi(r.FormValue("input_size_limit"))
-		if inputSizeLimit == 0 {
-			inputSizeLimit = 1024 // Default value
-		}
-
-		processedBytes, err := processImage(providedImageBytes, uint(inputSizeLimit))
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to process image: %v", err), http.StatusInternalServerError)
-			return
-		}
-		providedImageBytes = processedBytes
-		providedImageFilename = strings.TrimSuffix(providedImageFilename, ".png") + ".jpg" // Change extension to jpg
-
-		input.ImageBytes = providedImageBytes
-
-		// If the provider requires a URL, upload the image to the host first.
-		if provider.RequiresImageURL() {
-			if imageHostClient == nil {
-				http.Error(w, "Image hosting is not configured, cannot process image for this provider", http.StatusInternalServerError)
-				return
-			}
-			log.Println("Provider requires URL, uploading temporary image...")
-			uploadResp, err := imageHostClient.UploadImage(providedImageBytes, providedImageFilename)
-			if err != nil {
-				errStr := fmt.Sprintf("Failed to upload temporary image: %v", err)
-				log.Println(errStr)
-				http.Error(w, errStr, http.StatusInternalServerError)
-				return
-			}
-			input.ImageURL = uploadResp.Links.Direct
-			tempImageID = uploadResp.ImageID
-			log.Printf("Temporary image uploaded: %s (ID: %s)", input.ImageURL, tempImageID)
-
-			// Defer the deletion of the temporary image.
-			// This ensures it runs even if the provider call fails.
-			defer func() {
-				if tempImageID != "" {
-					log.Printf("Deleting temporary image with ID: %s", tempImageID)
-					if err := imageHostClient.DeleteImage(tempImageID); err != nil {
-						log.Printf("Warning: failed to delete temporary image %s: %v", tempImageID, err)
-					}
-				}
-			}()
-		}
-	}
-
-	// --- 3. Call the Provider ---
-	// Specific model validation: Check if models that require an image have one.
-	if (fullModelName == "Dreamifly/Flux-Kontext" || fullModelName == "Dreamifly/Qwen-Image-Edit") && len(input.ImageBytes) == 0 {
-		errStr := fmt.Sprintf("Model '%s' requires an image", fullModelName)
-		log.Println(errStr)
-		http.Error(w, errStr, http.StatusBadRequest)
-		return
+	maskFile, _, err := r.FormFile("mask")
+	if err != nil && err != http.ErrMissingFile {
+		return generationRequest{}, newAPIError(http.StatusBadRequest, "Could not retrieve mask from form")
 	}
-
-	log.Printf("Calling provider '%s' with model '%s'", providerName, modelName)
-	output, err := provider.Generate(input)
-	if err != nil {
-		errStr := fmt.Sprintf("Error from provider '%s': %v", providerName, err)
-		log.Println(errStr)
-		http.Error(w, errStr, http.StatusInternalServerError)
-		return // The deferred deletion will still run
+	if err == nil { // Mask file was provided
+		defer maskFile.Close()
+		genReq.MaskBytes, _ = io.ReadAll(maskFile)
 	}
+	return genReq, nil
+}
 
-	// --- 4. Handle Temporary Image Deletion ---
-	// The deletion is now handled by the deferred function call.
-	// The explicit deletion block is no longer needed here.
-
-	// --- 5. Process and Return Final Image ---
-	finalImageBytes := output.ImageBytes
-	// The logic for downloading from a provider's URL is now removed,
-	// as all providers are expected to return image bytes directly.
-
-	if len(finalImageBytes) == 0 {
-		http.Error(w, "Provider did not return any image data", http.StatusInternalServerError)
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Convert the final image to WebP for consistency and smaller size.
-	webpBytes, err := convertToWebP(finalImageBytes)
+	// --- 1. Parse Input ---
+	genReq, err := parseWebGenerationRequest(r)
 	if err != nil {
-		// If conversion fails, log the error but proceed with the original image.
-		log.Printf("Warning: failed to convert image to WebP: %v. Using original format.", err)
-		webpBytes = finalImageBytes // Fallback to original bytes
-	} else {
-		log.Printf("Successfully converted final image to WebP. Original size: %d, WebP size: %d", len(finalImageBytes), len(webpBytes))
+		log.Println(err)
+		http.Error(w, err.Error(), apiErrorStatus(err))
+		return
 	}
 
-	// Generate a filename for potential local saving or content disposition header.
-	now := time.Now()
-	randomSuffix := rand.Intn(1000)
-	finalFilename := fmt.Sprintf("%s_%03d.webp", now.Format("2006_0102_150405"), randomSuffix)
-	localFilepath := fmt.Sprintf("images/%s", finalFilename)
-
-	// Save the (potentially converted) image locally, if enabled.
-	if config.AppConfig.Settings.SaveLocalCopy {
-		if err := os.WriteFile(localFilepath, webpBytes, 0644); err != nil {
-			log.Printf("Warning: failed to save final image locally to %s: %v", localFilepath, err)
-		} else {
-			log.Printf("Successfully saved final image to %s", localFilepath)
-		}
-	} else {
+	// --- 2. Generate ---
+	if !genReq.SaveLocalCopy {
 		log.Println("Local save is disabled; skipping writing file to disk.")
 	}
+	result, err := runGeneration(r.Context(), genReq)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), apiErrorStatus(err))
+		return
+	}
+	results := result.Images
 
-	// --- 6. Decide How to Return the Image ---
+	// --- 3. Decide How to Return the Images ---
 	if !config.AppConfig.Settings.UploadToImageHost {
-		// Return image data directly
 		log.Println("UPLOAD_TO_IMAGE_HOST is false, returning image data directly.")
-		w.Header().Set("Content-Type", "image/webp")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", finalFilename))
-		w.Write(webpBytes)
-		log.Println("Successfully returned final image data to client.")
+		if len(results) == 1 {
+			// Return image data directly
+			w.Header().Set("Content-Type", "image/webp")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", results[0].Filename))
+			setMetadataHeaders(w, result.Metadata)
+			w.Write(results[0].Bytes)
+			log.Println("Successfully returned final image data to client.")
+			return
+		}
+
+		// Several images cannot share one binary body, so inline them as data URLs.
+		images := make([]webImage, len(results))
+		for i, res := range results {
+			images[i] = webImage{
+				URL:  "data:image/webp;base64," + base64.StdEncoding.EncodeToString(res.Bytes),
+				Seed: res.Seed,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webGenerateResponse{ImageURL: images[0].URL, Images: images, Translation: result.Translation, Metadata: &result.Metadata})
+		log.Printf("Successfully returned %d inline images to client.", len(images))
 		return
 	}
 
-	// --- 7. Upload and Return URL (Default Behavior) ---
-	if imageHostClient == nil {
+	// --- 4. Upload and Return URLs (Default Behavior) ---
+	if resultImageHost == nil {
 		errStr := "Image hosting is not configured, cannot return final image URL. Set UPLOAD_TO_IMAGE_HOST=false to return image data directly."
 		log.Println(errStr)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("Uploading final image to image host...")
-	finalUpload, err := imageHostClient.UploadImage(webpBytes, localFilepath)
-	if err != nil {
-		errStr := fmt.Sprintf("Failed to upload final image: %v", err)
-		log.Println(errStr)
-		http.Error(w, errStr, http.StatusInternalServerError)
-		return
+	log.Printf("Uploading %d final image(s) to image host...", len(results))
+	images := make([]webImage, len(results))
+	uploadStart := time.Now()
+	for i, res := range results {
+		finalUpload, err := resultImageHost.Upload(r.Context(), res.Bytes, res.Filename)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to upload final image: %v", err)
+			log.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		images[i] = webImage{URL: finalUpload.URL, Seed: res.Seed}
 	}
+	result.Metadata.Timings.addUpload(uploadStart)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"imageUrl": finalUpload.Links.Direct,
-	})
-	log.Printf("Successfully returned final image URL to client: %s", finalUpload.Links.Direct)
+	json.NewEncoder(w).Encode(webGenerateResponse{ImageURL: images[0].URL, Images: images, Translation: result.Translation, Metadata: &result.Metadata})
+	log.Printf("Successfully returned final image URL to client: %s", images[0].URL)
+}
+
+// webImage describes one generated image in a web UI response.
+type webImage struct {
+	URL  string `json:"url"`
+	Seed int64  `json:"seed,omitempty"`
+}
+
+// webGenerateResponse is the JSON body returned by /api/generate. ImageURL
+// repeats the first image for clients that only expect a single result.
+type webGenerateResponse struct {
+	ImageURL    string              `json:"imageUrl"`
+	Images      []webImage          `json:"images"`
+	Translation *promptTranslation  `json:"translation,omitempty"`
+	Metadata    *generationMetadata `json:"metadata,omitempty"`
+}
+
+// generationContext derives the context for a single generation from the
+// incoming request context, applying the configured provider/model deadline.
+func generationContext(parent context.Context, providerName, modelName string) (context.Context, context.CancelFunc) {
+	if timeout := config.AppConfig.GenerationTimeout(providerName, modelName); timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// providerErrorStatus maps a provider error to the HTTP status returned to the client.
+func providerErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, providers.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
 // processImage resizes and compresses an image.
@@ -531,58 +635,34 @@ func convertToWebP(imageBytes []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func handleOptimizePrompt(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Could not read request body", http.StatusInternalServerError)
-		return
-	}
-	defer r.Body.Close()
-
-	var requestData struct {
-		Prompt string `json:"prompt"`
-	}
-
-	if err := json.Unmarshal(body, &requestData); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-		return
-	}
+// --- V1 API Handlers ---
 
-	originalPrompt := requestData.Prompt
-	// Get the dreamifly provider. We assume it's always registered.
-	provider, ok := providerRegistry["Dreamifly"].(*providers.DreamiflyProvider)
-	if !ok {
-		http.Error(w, "Dreamifly provider is not available", http.StatusInternalServerError)
-		return
-	}
+// handleAPIGetModels serves the list of available models for the external API.
+// It reuses the same logic as the internal model handler but is exposed on a new, versioned endpoint.
+func handleAPIGetModels(w http.ResponseWriter, r *http.Request) {
+	handleGetModels(w, r)
+}
 
-	optimizedPrompt, err := provider.OptimizePrompt(originalPrompt)
-	if err != nil {
-		errStr := fmt.Sprintf("Error from prompt optimization provider: %v", err)
-		log.Println(errStr)
-		http.Error(w, errStr, http.StatusInternalServerError)
+// handleAPIProviderStatus reports the health and circuit breaker state of
+// every provider, sorted by name.
+func handleAPIProviderStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "Only GET method is allowed"))
 		return
 	}
 
-	responseData := map[string]string{
-		"optimized_prompt": optimizedPrompt,
+	statuses := []providers.ProviderStatus{}
+	for name, provider := range providerRegistry {
+		if tracked, ok := provider.(*providers.HealthTrackedProvider); ok {
+			statuses = append(statuses, tracked.Status())
+		} else {
+			statuses = append(statuses, providers.ProviderStatus{Provider: name, Status: providers.HealthHealthy, CircuitState: providers.CircuitClosed})
+		}
 	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Provider < statuses[j].Provider })
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responseData)
-}
-
-// --- V1 API Handlers ---
-
-// handleAPIGetModels serves the list of available models for the external API.
-// It reuses the same logic as the internal model handler but is exposed on a new, versioned endpoint.
-func handleAPIGetModels(w http.ResponseWriter, r *http.Request) {
-	handleGetModels(w, r)
+	json.NewEncoder(w).Encode(statuses)
 }
 
 // APIGenerateRequest defines the expected JSON structure for the v1 generate endpoint.
@@ -592,15 +672,80 @@ type APIGenerateRequest struct {
 	Width    int    `json:"width"`
 	Height   int    `json:"height"`
 	Model    string `json:"model"`
+	Style    string `json:"style,omitempty"` // Name of a style preset, see GET /api/v1/styles
 	Seed     int64  `json:"seed,omitempty"`
 	Steps    int    `json:"steps,omitempty"`
+	N        int    `json:"n,omitempty"` // Number of images to generate, defaults to 1
+
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Guidance       float64 `json:"guidance,omitempty"`
+	Strength       float64 `json:"strength,omitempty"`
+	// LoRA adapters, for models that list "loras" in supported_params.
+	LoRAs []providers.LoRA `json:"loras,omitempty"`
+
+	// Inpainting mask, either as an image URL or as rectangles in input image
+	// coordinates. Only for models that list "mask" in supported_params.
+	MaskURL   string     `json:"mask_url,omitempty"`
+	MaskRects []MaskRect `json:"mask_rects,omitempty"`
+}
+
+// APIImage describes one generated image in a v1 response.
+type APIImage struct {
+	URL  string `json:"url"`
+	Seed int64  `json:"seed,omitempty"`
 }
 
 // APIGenerateResponse defines the JSON structure for the v1 generate endpoint response.
+// ImageURL is the first entry of Images, kept for single-image clients.
 type APIGenerateResponse struct {
-	Status   string `json:"status"`
-	ImageURL string `json:"image_url,omitempty"`
-	Error    string `json:"error,omitempty"`
+	Status   string     `json:"status"`
+	ImageURL string     `json:"image_url,omitempty"`
+	Images   []APIImage `json:"images,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Translation reports the translated prompt for models that take English.
+	Translation *promptTranslation `json:"translation,omitempty"`
+	// Metadata holds the effective settings, to reproduce the generation.
+	Metadata *generationMetadata `json:"metadata,omitempty"`
+	// Fields lists every invalid request field when validation fails.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// apiError is an error that carries the HTTP status code to report to API clients.
+type apiError struct {
+	Status  int
+	Message string
+	Fields  []FieldError // The invalid fields, for validation errors
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, format string, args ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// apiErrorStatus returns the HTTP status code carried by an *apiError, or
+// 500 for any other error.
+func apiErrorStatus(err error) int {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// writeAPIError writes err as a v1 error response, using the status code from
+// an *apiError when available.
+func writeAPIError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErrorStatus(err))
+	resp := APIGenerateResponse{Status: "error", Error: err.Error()}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		resp.Fields = apiErr.Fields
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
 // handleAPIGenerate handles image generation requests from the external API.
@@ -612,159 +757,90 @@ func handleAPIGenerate(w http.ResponseWriter, r *http.Request) {
 
 	// 1. Decode JSON Request
 	var apiReq APIGenerateRequest
-	if err := json.NewDecoder(r.Body).Decode(&apiReq); err != nil {
-		http.Error(w, "Invalid JSON request body", http.StatusBadRequest)
+	if err := decodeJSONRequest(r, &apiReq); err != nil {
+		writeAPIError(w, err)
 		return
 	}
 	defer r.Body.Close()
 
-	// 2. Validate Input
-	if apiReq.Prompt == "" || apiReq.Model == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: "'prompt' and 'model' fields are required"})
-		return
-	}
-
-	providerName, modelName, err := providers.ParseModelName(apiReq.Model)
+	resp, err := runAPIGeneration(r.Context(), apiReq)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: err.Error()})
+		writeAPIError(w, err)
 		return
 	}
 
-	provider, ok := providerRegistry[providerName]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Provider '%s' not found or not configured", providerName)})
-		return
-	}
-
-	// 3. Prepare Generation Input
-	width, height := apiReq.Width, apiReq.Height
-	if width == 0 {
-		width = 1024
-	}
-	if height == 0 {
-		height = 1024
-	}
-
-	input := providers.GenerationInput{
-		Prompt: apiReq.Prompt,
-		Model:  modelName,
-		Width:  width,
-		Height: height,
-		Seed:   apiReq.Seed,
-		Steps:  apiReq.Steps,
-	}
-	if input.Seed == 0 {
-		input.Seed = rand.Int63n(1000000)
-	}
-
-	// 4. Handle Image Input (from URL)
-	var providedImageBytes []byte
-	if apiReq.ImageURL != "" {
-		log.Printf("API: Downloading image from provided URL: %s", apiReq.ImageURL)
-		downloadedBytes, _, err := providers.DownloadFile(apiReq.ImageURL)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Failed to download image from URL: %v", err)})
-			return
-		}
-		providedImageBytes = downloadedBytes
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	log.Printf("API: Successfully returned final image URL to client: %s", resp.ImageURL)
+}
 
-	if len(providedImageBytes) > 0 {
-		// Process the image (resize/compress)
-		processedBytes, err := processImage(providedImageBytes, 1024) // Default 1024px limit for API
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Failed to process image: %v", err)})
-			return
-		}
-		input.ImageBytes = processedBytes
-
-		// If the provider requires a URL, we must upload it.
-		if provider.RequiresImageURL() {
-			if imageHostClient == nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: "Image hosting is not configured, cannot process image for this provider"})
-				return
-			}
-			log.Println("API: Provider requires URL, uploading temporary image...")
-			uploadResp, err := imageHostClient.UploadImage(processedBytes, "api_input.jpg")
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Failed to upload temporary image: %v", err)})
-				return
-			}
-			input.ImageURL = uploadResp.Links.Direct
-			// We don't delete this temp image for API calls, for simplicity.
-			// A more robust implementation might have a cleanup worker.
-		}
+// validateAPIRequest checks a v1 generate request against the model's
+// capabilities without calling the provider.
+func validateAPIRequest(apiReq APIGenerateRequest) error {
+	req := apiReq.generationRequest()
+	if err := applyStyle(&req); err != nil {
+		return err
 	}
-
-	// 5. Call the Provider
-	// Specific model validation: Check if models that require an image have one.
-	if (apiReq.Model == "Dreamifly/Flux-Kontext" || apiReq.Model == "Dreamifly/Qwen-Image-Edit") && apiReq.ImageURL == "" {
-		errStr := fmt.Sprintf("Model '%s' requires an 'image_url'", apiReq.Model)
-		log.Printf("API: Validation Error: %s", errStr)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: errStr})
-		return
+	if _, _, err := validateGenerationRequest(&req); err != nil {
+		log.Printf("API: Validation Error: %v", err)
+		return err
 	}
+	return nil
+}
 
-	log.Printf("API: Calling provider '%s' with model '%s'", providerName, modelName)
-	output, err := provider.Generate(input)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Error from provider '%s': %v", providerName, err)})
-		return
+// generationRequest converts a v1 request to a generationRequest. API calls
+// always save a local copy of the results.
+func (apiReq APIGenerateRequest) generationRequest() generationRequest {
+	return generationRequest{
+		Model:          apiReq.Model,
+		Style:          apiReq.Style,
+		Prompt:         apiReq.Prompt,
+		NegativePrompt: apiReq.NegativePrompt,
+		Width:          apiReq.Width,
+		Height:         apiReq.Height,
+		Seed:           apiReq.Seed,
+		Steps:          apiReq.Steps,
+		Guidance:       apiReq.Guidance,
+		Strength:       apiReq.Strength,
+		LoRAs:          apiReq.LoRAs,
+		N:              apiReq.N,
+		ImageURL:       apiReq.ImageURL,
+		ImageFilename:  "api_input.jpg",
+		MaskURL:        apiReq.MaskURL,
+		MaskRects:      apiReq.MaskRects,
+		InputSizeLimit: 1024, // Default 1024px limit for API
+		SaveLocalCopy:  true,
 	}
+}
 
-	if len(output.ImageBytes) == 0 {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: "Provider did not return any image data"})
-		return
+// runAPIGeneration performs a complete v1 generation: it validates the request,
+// calls the provider, stores the result and uploads it to the image host.
+// It is shared by the synchronous endpoint and the asynchronous job workers.
+func runAPIGeneration(ctx context.Context, apiReq APIGenerateRequest) (*APIGenerateResponse, error) {
+	// API calls always upload the results, so fail before doing any work.
+	if resultImageHost == nil {
+		return nil, newAPIError(http.StatusInternalServerError, "Image hosting is not configured, cannot return final image URL.")
 	}
 
-	// 6. Process and Upload Final Image (API calls always save and upload)
-	webpBytes, err := convertToWebP(output.ImageBytes)
+	// 2. Validate and Generate
+	result, err := runGeneration(ctx, apiReq.generationRequest())
 	if err != nil {
-		log.Printf("Warning: failed to convert image to WebP: %v. Using original format.", err)
-		webpBytes = output.ImageBytes
+		return nil, err
 	}
 
-	now := time.Now()
-	randomSuffix := rand.Intn(1000)
-	finalFilename := fmt.Sprintf("%s_%03d.webp", now.Format("2006_0102_150405"), randomSuffix)
-	localFilepath := fmt.Sprintf("images/%s", finalFilename)
-
-	// Save locally
-	if err := os.WriteFile(localFilepath, webpBytes, 0644); err != nil {
-		log.Printf("API Warning: failed to save final image locally to %s: %v", localFilepath, err)
-	} else {
-		log.Printf("API: Successfully saved final image to %s", localFilepath)
-	}
-
-	// Upload to image host
-	if imageHostClient == nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: "Image hosting is not configured, cannot return final image URL."})
-		return
-	}
-
-	finalUpload, err := imageHostClient.UploadImage(webpBytes, localFilepath)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIGenerateResponse{Status: "error", Error: fmt.Sprintf("Failed to upload final image: %v", err)})
-		return
+	// 3. Upload Final Images
+	resp := &APIGenerateResponse{Status: "success", Translation: result.Translation, Metadata: &result.Metadata}
+	uploadStart := time.Now()
+	for _, res := range result.Images {
+		finalUpload, err := resultImageHost.Upload(ctx, res.Bytes, res.Filename)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, "Failed to upload final image: %v", err)
+		}
+		resp.Images = append(resp.Images, APIImage{URL: finalUpload.URL, Seed: res.Seed})
 	}
+	resp.Metadata.Timings.addUpload(uploadStart)
 
-	// 7. Return Success Response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(APIGenerateResponse{
-		Status:   "success",
-		ImageURL: finalUpload.Links.Direct,
-	})
-	log.Printf("API: Successfully returned final image URL to client: %s", finalUpload.Links.Direct)
+	// 4. Return Success Response
+	resp.ImageURL = resp.Images[0].URL
+	return resp, nil
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// MaskRect is a rectangular area to repaint, given in pixel coordinates of
+// the input image: (X1, Y1) is the top-left and (X2, Y2) the bottom-right
+// corner, both inclusive.
+type MaskRect struct {
+	X1 int `json:"x1"`
+	Y1 int `json:"y1"`
+	X2 int `json:"x2"`
+	Y2 int `json:"y2"`
+}
+
+// parseMaskRects parses rectangles written as "x1,y1,x2,y2", separated by
+// semicolons, as submitted by the web form.
+func parseMaskRects(s string) ([]MaskRect, error) {
+	var rects []MaskRect
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid mask rectangle '%s', expected x1,y1,x2,y2", part)
+		}
+		var coords [4]int
+		for i, field := range fields {
+			v, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return nil, fmt.Errorf("invalid mask rectangle '%s', expected x1,y1,x2,y2", part)
+			}
+			coords[i] = v
+		}
+		rects = append(rects, MaskRect{X1: coords[0], Y1: coords[1], X2: coords[2], Y2: coords[3]})
+	}
+	return rects, nil
+}
+
+// rectangleMask renders rects into a width x height grayscale PNG mask.
+// Areas to repaint are white (255), everything else is black (0).
+func rectangleMask(width, height int, rects []MaskRect) ([]byte, error) {
+	mask := image.NewGray(image.Rect(0, 0, width, height))
+	for _, r := range rects {
+		// Make sure the coordinates are ordered (x1 <= x2, y1 <= y2)
+		x1, x2 := min(r.X1, r.X2), max(r.X1, r.X2)
+		y1, y2 := min(r.Y1, r.Y2), max(r.Y1, r.Y2)
+		area := image.Rect(x1, y1, x2+1, y2+1).Intersect(mask.Bounds())
+		if area.Empty() {
+			return nil, fmt.Errorf("mask rectangle (%d,%d)-(%d,%d) is outside the %dx%d image", r.X1, r.Y1, r.X2, r.Y2, width, height)
+		}
+		draw.Draw(mask, area, image.NewUniform(color.Gray{Y: 255}), image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, mask); err != nil {
+		return nil, fmt.Errorf("failed to encode mask: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// processMask converts an uploaded mask to a grayscale PNG of the given size,
+// so that it lines up with the processed input image. Masks with
+// transparency follow the OpenAI convention instead, where transparent
+// pixels mark the area to repaint; their alpha channel becomes the mask.
+func processMask(maskBytes []byte, width, height int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(maskBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mask: %w", err)
+	}
+
+	if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+		img = resize.Resize(uint(width), uint(height), img, resize.NearestNeighbor)
+	}
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if hasTransparency(img) {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				_, _, _, a := img.At(x, y).RGBA()
+				gray.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: 255 - uint8(a>>8)})
+			}
+		}
+	} else {
+		draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return nil, fmt.Errorf("failed to encode mask: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// hasTransparency reports whether any pixel of img is not fully opaque.
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"imageapi/providers"
+)
+
+// generationMetadata records the effective settings of a generation: the
+// values the provider was called with after styles, translation, validation
+// and defaults were applied. Sending them back as a new request reproduces
+// the generation on models with deterministic seeds. Parameters the model
+// does not support are left out, so the values are always valid to resend.
+type generationMetadata struct {
+	ID             string            `json:"id"`
+	Provider       string            `json:"provider"`
+	Model          string            `json:"model"`                   // Full model name, "provider/model"
+	AppliedStyle   string            `json:"applied_style,omitempty"` // Already part of the fields below, so not a request field
+	Prompt         string            `json:"prompt"`                  // As sent to the provider
+	NegativePrompt string            `json:"negative_prompt,omitempty"`
+	Seed           int64             `json:"seed,omitempty"` // Seed of the request; images report their own seeds where known
+	Steps          int               `json:"steps,omitempty"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	Guidance       float64           `json:"guidance,omitempty"`
+	Strength       float64           `json:"strength,omitempty"` // Denoise strength of image-to-image requests
+	LoRAs          []providers.LoRA  `json:"loras,omitempty"`
+	N              int               `json:"n"`
+	Timings        generationTimings `json:"timings"`
+}
+
+// generationTimings breaks down where the time of a generation went, in
+// milliseconds. Total is the wall time of the whole generation, which also
+// covers validation and prompt translation.
+type generationTimings struct {
+	Download int64 `json:"download_ms"` // Fetching the input image and mask
+	Upload   int64 `json:"upload_ms"`   // Temporary input and final result uploads
+	Provider int64 `json:"provider_ms"`
+	Encode   int64 `json:"encode_ms"` // Resizing the input and converting the results
+	Total    int64 `json:"total_ms"`
+}
+
+// addUpload accounts for the upload of the results, which happens after
+// runGeneration returns.
+func (t *generationTimings) addUpload(start time.Time) {
+	elapsed := time.Since(start).Milliseconds()
+	t.Upload += elapsed
+	t.Total += elapsed
+}
+
+// newGenerationID returns a random ID that identifies a generation in
+// responses and logs.
+func newGenerationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Warning: failed to generate generation ID: %v", err)
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// newGenerationMetadata describes a validated request about to be sent to
+// the provider.
+func newGenerationMetadata(id, providerName string, caps providers.ModelCapabilities, req generationRequest, input providers.GenerationInput) generationMetadata {
+	meta := generationMetadata{
+		ID:             id,
+		Provider:       providerName,
+		Model:          req.Model,
+		AppliedStyle:   req.Style,
+		Prompt:         input.Prompt,
+		NegativePrompt: input.NegativePrompt,
+		Steps:          input.Steps,
+		Width:          input.Width,
+		Height:         input.Height,
+		Guidance:       input.Guidance,
+		Strength:       input.Strength,
+		LoRAs:          input.LoRAs,
+		N:              req.N,
+	}
+	if caps.Supports("seed") {
+		meta.Seed = input.Seed
+	}
+	return meta
+}
+
+// setMetadataHeaders describes a generation in response headers, for
+// responses whose body is the image itself. X-Generation-Metadata holds the
+// JSON metadata, base64 encoded since prompts need not be ASCII.
+func setMetadataHeaders(w http.ResponseWriter, meta generationMetadata) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		log.Printf("Warning: failed to encode generation metadata: %v", err)
+		return
+	}
+	w.Header().Set("X-Generation-Id", meta.ID)
+	w.Header().Set("X-Generation-Metadata", base64.StdEncoding.EncodeToString(data))
+}
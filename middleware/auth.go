@@ -26,7 +26,7 @@ func InitSessionStore() {
 	// The session key should be a long, random string.
 	// It's read from an environment variable for security.
 	sessionKey := config.AppConfig.Settings.SessionSecret
-	if sessionKey == "a_very_long_and_random_secret_string" {
+	if sessionKey == config.DefaultSessionSecret {
 		log.Println("Warning: SESSION_SECRET is not set or is the default. Using a default, insecure key. Please set a strong secret in your .env file for production.")
 	}
 	Store = sessions.NewCookieStore([]byte(sessionKey))
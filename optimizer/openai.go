@@ -0,0 +1,108 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"imageapi/httpclient"
+)
+
+// chatMessage is one message of an OpenAI chat completion request.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatMessages builds the conversation sent to a language model.
+func chatMessages(prompt, systemPrompt string) []chatMessage {
+	if systemPrompt == "" {
+		systemPrompt = DefaultSystemPrompt
+	}
+	return []chatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: prompt},
+	}
+}
+
+// OpenAIChat uses any OpenAI-compatible /chat/completions endpoint.
+type OpenAIChat struct {
+	name    string
+	BaseURL string // Including the version, e.g. "https://api.openai.com/v1"
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+// NewOpenAIChat creates a backend for the chat API at baseURL.
+func NewOpenAIChat(name, baseURL, apiKey, model string) *OpenAIChat {
+	return &OpenAIChat{
+		name:    name,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Model:   model,
+		Client:  httpclient.New(name),
+	}
+}
+
+// Name returns the name of the backend.
+func (o *OpenAIChat) Name() string {
+	return o.name
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// Optimize asks the chat model to rewrite prompt.
+func (o *OpenAIChat) Optimize(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	payload := struct {
+		Model    string        `json:"model"`
+		Messages []chatMessage `json:"messages"`
+	}{
+		Model:    o.Model,
+		Messages: chatMessages(prompt, systemPrompt),
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to marshal chat payload: %w", o.name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", o.BaseURL+"/chat/completions", bytes.NewReader(payloadBytes))
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to create chat request: %w", o.name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to call chat API: %w", o.name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to read chat response: %w", o.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: chat API returned non-200 status: %d, body: %s", o.name, resp.StatusCode, string(body))
+	}
+
+	var chatResp chatCompletionResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return "", fmt.Errorf("%s: failed to decode chat response: %w", o.name, err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("%s: chat API returned no choices", o.name)
+	}
+	return chatResp.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,128 @@
+// Package optimizer rewrites short user prompts into detailed image prompts
+// through interchangeable backends.
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// PromptOptimizer is implemented by every prompt optimization backend.
+type PromptOptimizer interface {
+	// Name identifies the backend in configuration and responses.
+	Name() string
+	// Optimize rewrites prompt following systemPrompt. Backends without a
+	// configurable language model may ignore systemPrompt.
+	Optimize(ctx context.Context, prompt, systemPrompt string) (string, error)
+}
+
+// DefaultSystemPrompt instructs language models when no style is requested.
+const DefaultSystemPrompt = "You are an expert prompt writer for text-to-image models. " +
+	"Rewrite the user's prompt into a single detailed English prompt that describes the subject, " +
+	"composition, lighting and details. Keep the user's intent and any quoted text unchanged. " +
+	"Reply with the prompt only, without explanations or quotes."
+
+// Styles holds the built-in system prompts selectable by name. Each extends
+// DefaultSystemPrompt with the look the rewritten prompt should aim for.
+var Styles = map[string]string{
+	"photographic": DefaultSystemPrompt + " Aim for a realistic photograph: mention the camera, lens, depth of field and natural lighting.",
+	"anime":        DefaultSystemPrompt + " Aim for an anime illustration: mention the art style, clean line art, cel shading and vibrant colors.",
+	"cinematic":    DefaultSystemPrompt + " Aim for a film still: mention the shot type, dramatic lighting, color grading and atmosphere.",
+	"illustration": DefaultSystemPrompt + " Aim for a digital illustration: mention the medium, brushwork, color palette and mood.",
+}
+
+// ErrUnknownBackend is returned when a request names a backend that is not configured.
+var ErrUnknownBackend = errors.New("unknown prompt optimizer backend")
+
+// Chain tries its backends in order until one succeeds.
+type Chain struct {
+	optimizers []PromptOptimizer
+	timeout    time.Duration // Per attempt; zero means no limit
+}
+
+// NewChain creates a chain over optimizers, in failover order.
+func NewChain(optimizers []PromptOptimizer, timeout time.Duration) *Chain {
+	return &Chain{optimizers: optimizers, timeout: timeout}
+}
+
+// Names returns the names of the backends in failover order.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.optimizers))
+	for i, o := range c.optimizers {
+		names[i] = o.Name()
+	}
+	return names
+}
+
+// Optimize rewrites prompt with the first backend that succeeds and returns
+// the result together with that backend's name. A non-empty backend restricts
+// the chain to the backend of that name.
+func (c *Chain) Optimize(ctx context.Context, prompt, systemPrompt, backend string) (string, string, error) {
+	candidates := c.optimizers
+	if backend != "" {
+		candidates = nil
+		for _, o := range c.optimizers {
+			if strings.EqualFold(o.Name(), backend) {
+				candidates = append(candidates, o)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", "", fmt.Errorf("%w: '%s'", ErrUnknownBackend, backend)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", errors.New("no prompt optimizer backend is configured")
+	}
+
+	var failures []interface{}
+	for _, o := range candidates {
+		optimized, err := c.attempt(ctx, o, prompt, systemPrompt)
+		if err == nil {
+			return optimized, o.Name(), nil
+		}
+		if ctx.Err() != nil || len(candidates) == 1 {
+			return "", "", err
+		}
+		log.Printf("Warning: prompt optimizer '%s' failed, trying the next backend: %v", o.Name(), err)
+		failures = append(failures, err)
+	}
+	// Wrap every failure so that callers can still match e.g. a deadline.
+	format := strings.TrimSuffix(strings.Repeat("%w; ", len(failures)), "; ")
+	return "", "", fmt.Errorf("all prompt optimizer backends failed: "+format, failures...)
+}
+
+// attempt calls a single backend under the per-attempt timeout.
+func (c *Chain) attempt(ctx context.Context, o PromptOptimizer, prompt, systemPrompt string) (string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	optimized, err := o.Optimize(ctx, prompt, systemPrompt)
+	if err != nil {
+		return "", err
+	}
+	optimized = cleanPrompt(optimized)
+	if optimized == "" {
+		return "", fmt.Errorf("%s: returned an empty prompt", o.Name())
+	}
+	return optimized, nil
+}
+
+// quotePairs are the quotes language models tend to wrap their answer in.
+var quotePairs = [][2]string{{`"`, `"`}, {"'", "'"}, {"`", "`"}, {"“", "”"}}
+
+// cleanPrompt strips surrounding whitespace and quotes from an answer.
+func cleanPrompt(s string) string {
+	s = strings.TrimSpace(s)
+	for _, q := range quotePairs {
+		if len(s) >= len(q[0])+len(q[1]) && strings.HasPrefix(s, q[0]) && strings.HasSuffix(s, q[1]) {
+			s = strings.TrimSpace(s[len(q[0]) : len(s)-len(q[1])])
+		}
+	}
+	return s
+}
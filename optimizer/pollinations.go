@@ -0,0 +1,82 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"imageapi/httpclient"
+)
+
+const (
+	pollinationsTextAPIURL = "https://text.pollinations.ai/"
+	pollinationsTextModel  = "openai"
+)
+
+// Pollinations uses the text generation endpoint of Pollinations.ai, which
+// works without an API key.
+type Pollinations struct {
+	name   string
+	APIKey string // Optional; sent as a bearer token when set
+	Model  string
+	Client *http.Client
+}
+
+// NewPollinations creates a Pollinations.ai backend. An empty model uses the
+// Pollinations default.
+func NewPollinations(name, apiKey, model string) *Pollinations {
+	if model == "" {
+		model = pollinationsTextModel
+	}
+	return &Pollinations{name: name, APIKey: apiKey, Model: model, Client: httpclient.New(name)}
+}
+
+// Name returns the name of the backend.
+func (p *Pollinations) Name() string {
+	return p.name
+}
+
+// Optimize asks the Pollinations text model to rewrite prompt. The endpoint
+// answers with plain text.
+func (p *Pollinations) Optimize(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	payload := struct {
+		Model    string        `json:"model"`
+		Messages []chatMessage `json:"messages"`
+		Private  bool          `json:"private"` // Keep the prompt out of the public feed
+	}{
+		Model:    p.Model,
+		Messages: chatMessages(prompt, systemPrompt),
+		Private:  true,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to marshal text payload: %w", p.name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", pollinationsTextAPIURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to create text request: %w", p.name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to call text API: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to read text response: %w", p.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: text API returned non-200 status: %d, body: %s", p.name, resp.StatusCode, string(body))
+	}
+	return string(body), nil
+}
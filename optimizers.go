@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"imageapi/config"
+	"imageapi/optimizer"
+)
+
+var (
+	// promptOptimizer tries the configured prompt optimization backends in order.
+	promptOptimizer *optimizer.Chain
+	// optimizerStyles maps style names to the system prompt they select.
+	optimizerStyles map[string]string
+)
+
+// initializePromptOptimizers creates the backends listed in the
+// PROMPT_OPTIMIZER config. A backend that cannot be created is skipped.
+func initializePromptOptimizers() {
+	settings := config.AppConfig.PromptOptimizer
+
+	var backends []optimizer.PromptOptimizer
+	for _, b := range settings.Backends {
+		backend, err := newPromptOptimizer(b)
+		if err != nil {
+			log.Printf("Warning: skipping prompt optimizer backend '%s': %v", b.Type, err)
+			continue
+		}
+		backends = append(backends, backend)
+	}
+	promptOptimizer = optimizer.NewChain(backends, time.Duration(settings.TimeoutSeconds)*time.Second)
+	log.Printf("Prompt optimizer backends: %s", strings.Join(promptOptimizer.Names(), ", "))
+
+	optimizerStyles = make(map[string]string, len(optimizer.Styles)+len(settings.Styles))
+	for name, systemPrompt := range optimizer.Styles {
+		optimizerStyles[name] = systemPrompt
+	}
+	for name, systemPrompt := range settings.Styles {
+		optimizerStyles[strings.ToLower(name)] = systemPrompt
+	}
+}
+
+// newPromptOptimizer creates the prompt optimization backend b declares.
+func newPromptOptimizer(b config.PromptOptimizerBackend) (optimizer.PromptOptimizer, error) {
+	switch strings.ToLower(b.Type) {
+	case "dreamifly":
+		if b.Name == "" {
+			b.Name = "Dreamifly"
+		}
+		return optimizer.NewDreamifly(b.Name), nil
+	case "pollinations":
+		if b.Name == "" {
+			b.Name = "Pollinations_ai"
+		}
+		if b.APIKey == "" {
+			b.APIKey = config.AppConfig.APIKeys.PollinationsAI
+		}
+		return optimizer.NewPollinations(b.Name, b.APIKey, b.Model), nil
+	case "openai":
+		if b.Name == "" || b.BaseURL == "" || b.Model == "" {
+			return nil, fmt.Errorf("NAME, BASE_URL and MODEL are required")
+		}
+		return optimizer.NewOpenAIChat(b.Name, b.BaseURL, b.APIKey, b.Model), nil
+	default:
+		return nil, fmt.Errorf("unknown TYPE '%s', expected dreamifly, pollinations or openai", b.Type)
+	}
+}
+
+// optimizePromptRequest is the JSON body of the prompt optimization endpoints.
+type optimizePromptRequest struct {
+	Prompt  string `json:"prompt"`
+	Style   string `json:"style,omitempty"`   // Name of a system prompt style
+	Backend string `json:"backend,omitempty"` // Use only this backend instead of failing over
+}
+
+// optimizePromptResponse is the result of a prompt optimization.
+type optimizePromptResponse struct {
+	OptimizedPrompt string `json:"optimized_prompt"`
+	Backend         string `json:"backend"`
+	Style           string `json:"style,omitempty"`
+}
+
+// optimizerInfo lists what a client can choose from, returned on GET.
+type optimizerInfo struct {
+	Backends []string `json:"backends"`
+	Styles   []string `json:"styles"`
+}
+
+// optimizePrompt validates req and runs it through the optimizer chain.
+// Errors are *apiError values carrying the HTTP status to report.
+func optimizePrompt(ctx context.Context, req optimizePromptRequest) (*optimizePromptResponse, error) {
+	if strings.TrimSpace(req.Prompt) == "" {
+		return nil, newValidationError([]FieldError{{Field: "prompt", Message: "is required"}})
+	}
+	style := strings.ToLower(strings.TrimSpace(req.Style))
+	systemPrompt := optimizer.DefaultSystemPrompt
+	if style != "" {
+		var ok bool
+		if systemPrompt, ok = optimizerStyles[style]; !ok {
+			return nil, newValidationError([]FieldError{{Field: "style", Message: fmt.Sprintf("unknown style '%s'", req.Style)}})
+		}
+	}
+
+	optimized, backend, err := promptOptimizer.Optimize(ctx, req.Prompt, systemPrompt, req.Backend)
+	if err != nil {
+		if errors.Is(err, optimizer.ErrUnknownBackend) {
+			return nil, newValidationError([]FieldError{{Field: "backend", Message: err.Error()}})
+		}
+		log.Printf("Error from prompt optimization: %v", err)
+		return nil, newAPIError(providerErrorStatus(err), "Error from prompt optimization provider: %v", err)
+	}
+	return &optimizePromptResponse{OptimizedPrompt: optimized, Backend: backend, Style: style}, nil
+}
+
+// promptOptimizerInfo returns the configured backends and the style names.
+func promptOptimizerInfo() optimizerInfo {
+	styles := make([]string, 0, len(optimizerStyles))
+	for name := range optimizerStyles {
+		styles = append(styles, name)
+	}
+	sort.Strings(styles)
+	return optimizerInfo{Backends: promptOptimizer.Names(), Styles: styles}
+}
+
+// handleOptimizePrompt serves the prompt optimizer of the web UI. GET lists
+// the backends and styles; POST optimizes a prompt.
+func handleOptimizePrompt(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(promptOptimizerInfo())
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData optimizePromptRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := optimizePrompt(r.Context(), requestData)
+	if err != nil {
+		http.Error(w, err.Error(), apiErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAPIOptimizePrompt is the v1 API version of handleOptimizePrompt.
+func handleAPIOptimizePrompt(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(promptOptimizerInfo())
+		return
+	case http.MethodPost:
+	default:
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "Only GET and POST methods are allowed"))
+		return
+	}
+
+	var requestData optimizePromptRequest
+	if err := decodeJSONRequest(r, &requestData); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	resp, err := optimizePrompt(r.Context(), requestData)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
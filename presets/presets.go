@@ -0,0 +1,122 @@
+// Package presets loads the style presets that turn a plain prompt into a
+// prompt for a particular art style.
+package presets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PromptPlaceholder marks where the user's prompt goes in a preset's prompt.
+const PromptPlaceholder = "{prompt}"
+
+// Preset is a named style. Its fields other than Name and Prompt are defaults
+// that apply when a request leaves the corresponding field empty.
+type Preset struct {
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	Prompt         string `json:"prompt"` // Template containing PromptPlaceholder
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	Model          string `json:"model,omitempty"` // Preferred model, "provider/model"
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	Steps          int    `json:"steps,omitempty"`
+	// Strength is the denoise strength for image-to-image requests.
+	Strength float64 `json:"strength,omitempty"`
+}
+
+// Expand inserts prompt into the preset's prompt template.
+func (p Preset) Expand(prompt string) string {
+	return strings.ReplaceAll(p.Prompt, PromptPlaceholder, strings.TrimSpace(prompt))
+}
+
+// validate checks that the preset can be applied.
+func (p Preset) validate() error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("preset has no name")
+	case !strings.Contains(p.Prompt, PromptPlaceholder):
+		return fmt.Errorf("prompt of preset '%s' does not contain %s", p.Name, PromptPlaceholder)
+	case p.Width < 0 || p.Height < 0 || p.Steps < 0:
+		return fmt.Errorf("preset '%s' has a negative size or step count", p.Name)
+	case p.Strength < 0 || p.Strength > 1:
+		return fmt.Errorf("strength of preset '%s' must be between 0 and 1", p.Name)
+	}
+	return nil
+}
+
+// Library holds presets by lower-case name.
+type Library struct {
+	presets map[string]Preset
+}
+
+// Get returns the preset with the given name, ignoring case.
+func (l *Library) Get(name string) (Preset, bool) {
+	p, ok := l.presets[strings.ToLower(strings.TrimSpace(name))]
+	return p, ok
+}
+
+// List returns every preset, sorted by name.
+func (l *Library) List() []Preset {
+	list := make([]Preset, 0, len(l.presets))
+	for _, p := range l.presets {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// LoadDir reads every *.json file in dir. A file holds either one preset or
+// an array of presets; later files replace presets of the same name. Invalid
+// presets are skipped and reported in the returned warnings, so one bad file
+// does not disable the others. A missing directory yields an empty library.
+func LoadDir(dir string) (*Library, []error) {
+	library := &Library{presets: make(map[string]Preset)}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return library, []error{err}
+	}
+	sort.Strings(files)
+
+	var warnings []error
+	for _, file := range files {
+		presets, err := readFile(file)
+		if err != nil {
+			warnings = append(warnings, err)
+			continue
+		}
+		for _, p := range presets {
+			if err := p.validate(); err != nil {
+				warnings = append(warnings, fmt.Errorf("%s: %w", file, err))
+				continue
+			}
+			library.presets[strings.ToLower(p.Name)] = p
+		}
+	}
+	return library, warnings
+}
+
+// readFile decodes the presets of one file.
+func readFile(file string) ([]Preset, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) > 0 && data[0] == '[' {
+		var presets []Preset
+		if err := json.Unmarshal(data, &presets); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		return presets, nil
+	}
+	var preset Preset
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return []Preset{preset}, nil
+}
@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ModelDiscoverer is implemented by providers that can list their models
+// from an upstream catalog. Discovered models are merged with the static list
+// returned by GetModels, whose entries take precedence, so hand-written
+// capabilities act as overrides for models the upstream also reports.
+type ModelDiscoverer interface {
+	DiscoverModels(ctx context.Context) ([]ModelCapabilities, error)
+}
+
+// discoveryTimeout bounds a single call to an upstream model catalog.
+const discoveryTimeout = 30 * time.Second
+
+// catalog caches the merged model list of every provider that implements
+// ModelDiscoverer, keyed by provider name.
+var catalog = struct {
+	sync.RWMutex
+	models map[string][]ModelCapabilities
+}{models: make(map[string][]ModelCapabilities)}
+
+// Models returns the models offered by provider: the merged catalog once
+// discovery has succeeded, and the static list from GetModels until then.
+func Models(provider ImageProvider) []ModelCapabilities {
+	catalog.RLock()
+	models, ok := catalog.models[provider.GetName()]
+	catalog.RUnlock()
+	if ok {
+		return models
+	}
+	return provider.GetModels()
+}
+
+// StartModelDiscovery queries the catalog of every provider implementing
+// ModelDiscoverer right away and then once per ttl, until ctx is done. A
+// failed refresh keeps the previously cached list.
+func StartModelDiscovery(ctx context.Context, providers []ImageProvider, ttl time.Duration) {
+	for _, provider := range providers {
+		discoverer, ok := provider.(ModelDiscoverer)
+		if !ok {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(ttl)
+			defer ticker.Stop()
+			for {
+				refreshModels(ctx, provider, discoverer)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// refreshModels queries the upstream catalog of provider and caches the result
+// merged with the static models.
+func refreshModels(ctx context.Context, provider ImageProvider, discoverer ModelDiscoverer) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	discovered, err := discoverer.DiscoverModels(ctx)
+	if err != nil {
+		log.Printf("Warning: model discovery for provider '%s' failed, keeping the previous model list: %v", provider.GetName(), err)
+		return
+	}
+
+	static := provider.GetModels()
+	merged := mergeModels(static, discovered)
+	catalog.Lock()
+	catalog.models[provider.GetName()] = merged
+	catalog.Unlock()
+	log.Printf("Model catalog of provider '%s' refreshed: %d models, %d discovered upstream", provider.GetName(), len(merged), len(merged)-len(static))
+}
+
+// mergeModels appends the discovered models that are not already declared in
+// static. Static declarations win because they carry tuned capabilities.
+func mergeModels(static, discovered []ModelCapabilities) []ModelCapabilities {
+	merged := append([]ModelCapabilities(nil), static...)
+	known := make(map[string]bool, len(static)+len(discovered))
+	for _, m := range static {
+		known[m.Name] = true
+	}
+	for _, m := range discovered {
+		if m.Name == "" || known[m.Name] {
+			continue
+		}
+		known[m.Name] = true
+		merged = append(merged, m)
+	}
+	return merged
+}
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -10,10 +11,13 @@ import (
 	"net/http"
 
 	"imageapi/config"
+	"imageapi/httpclient"
 )
 
 const (
 	cloudflareAPIURLFormat = "https://api.cloudflare.com/client/v4/accounts/%s/ai/run/%s"
+	// cloudflareModelSearchURLFormat lists the text-to-image models of Workers AI.
+	cloudflareModelSearchURLFormat = "https://api.cloudflare.com/client/v4/accounts/%s/ai/models/search?task=Text-to-Image&per_page=100"
 )
 
 // CloudflareProvider implements the ImageProvider for Cloudflare.
@@ -24,8 +28,20 @@ type CloudflareProvider struct {
 }
 
 var cloudflareModels = []ModelCapabilities{
-	{Name: "@cf/black-forest-labs/flux-1-schnell", SupportedParams: []string{"steps"}, MaxWidth: 1024, MaxHeight: 1024, MinSteps: 4, MaxSteps: 8, DefaultSteps: 8},
-	{Name: "@cf/stabilityai/stable-diffusion-xl-base-1.0", SupportedParams: []string{"width", "height"}, MaxWidth: 1024, MaxHeight: 1024},
+	{Name: "@cf/black-forest-labs/flux-1-schnell", SupportedParams: []string{"steps"}, MaxWidth: 1024, MaxHeight: 1024, MinSteps: 4, MaxSteps: 8, DefaultSteps: 8, EnglishPrompt: true},
+	{Name: "@cf/stabilityai/stable-diffusion-xl-base-1.0", SupportedParams: []string{"width", "height", "negative_prompt", "guidance"}, MaxWidth: 1024, MaxHeight: 1024, SizeMultiple: 8, EnglishPrompt: true,
+		ParamRanges: map[string]ParamRange{"guidance": {Min: 1, Max: 20, Default: 7.5}}},
+	// The Stable Diffusion 1.5 image models keep the size of the input image.
+	{Name: "@cf/runwayml/stable-diffusion-v1-5-img2img", SupportedParams: []string{"negative_prompt", "guidance", "strength", "steps", "seed"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportBytes, MaxWidth: 1024, MaxHeight: 1024, MinSteps: 1, MaxSteps: 20, DefaultSteps: 20, EnglishPrompt: true,
+		ParamRanges: map[string]ParamRange{"guidance": {Min: 1, Max: 20, Default: 7.5}, "strength": {Min: 0, Max: 1, Default: 0.7}}},
+	{Name: "@cf/runwayml/stable-diffusion-v1-5-inpainting", SupportedParams: []string{"negative_prompt", "guidance", "strength", "steps", "seed"}, Tasks: []Task{TaskInpaint}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportBytes, MaxWidth: 1024, MaxHeight: 1024, MinSteps: 1, MaxSteps: 20, DefaultSteps: 20, EnglishPrompt: true,
+		ParamRanges: map[string]ParamRange{"guidance": {Min: 1, Max: 20, Default: 7.5}, "strength": {Min: 0, Max: 1, Default: 1}}},
+}
+
+// cloudflareNumStepsModels take the step count as "num_steps" instead of "steps".
+var cloudflareNumStepsModels = map[string]bool{
+	"@cf/runwayml/stable-diffusion-v1-5-img2img":    true,
+	"@cf/runwayml/stable-diffusion-v1-5-inpainting": true,
 }
 
 // NewCloudflareProvider creates a new Cloudflare client if credentials are provided.
@@ -38,7 +54,7 @@ func NewCloudflareProvider() *CloudflareProvider {
 	}
 
 	return &CloudflareProvider{
-		Client:    &http.Client{},
+		Client:    httpclient.New("Cloudflare"),
 		AccountID: accountID,
 		APIToken:  apiToken,
 	}
@@ -49,22 +65,90 @@ func (p *CloudflareProvider) GetName() string {
 	return "Cloudflare"
 }
 
-// RequiresImageURL returns false as Cloudflare does not support image-to-image in this model.
-func (p *CloudflareProvider) RequiresImageURL() bool {
-	return false
-}
-
 // GetModels returns the list of models and their capabilities for Cloudflare.
 func (p *CloudflareProvider) GetModels() []ModelCapabilities {
 	return cloudflareModels
 }
 
+// cloudflareModelSearchResponse matches the response of the model search endpoint.
+type cloudflareModelSearchResponse struct {
+	Result []struct {
+		Name string `json:"name"`
+		Task struct {
+			Name string `json:"name"`
+		} `json:"task"`
+	} `json:"result"`
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// DiscoverModels lists the text-to-image models available on the account.
+// Models without a static declaration only receive the prompt.
+func (p *CloudflareProvider) DiscoverModels(ctx context.Context) ([]ModelCapabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(cloudflareModelSearchURLFormat, p.AccountID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: failed to create model search request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIToken)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: failed to search models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError(resp.StatusCode, "cloudflare: model search returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var searchResp cloudflareModelSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
+		return nil, fmt.Errorf("cloudflare: failed to decode model search response: %w", err)
+	}
+	if !searchResp.Success {
+		if len(searchResp.Errors) > 0 {
+			return nil, fmt.Errorf("cloudflare: model search error: %s", searchResp.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("cloudflare: model search reported failure but returned no error details")
+	}
+
+	var models []ModelCapabilities
+	for _, m := range searchResp.Result {
+		if m.Task.Name != "Text-to-Image" {
+			continue
+		}
+		models = append(models, ModelCapabilities{Name: m.Name, MaxWidth: 1024, MaxHeight: 1024, EnglishPrompt: true})
+	}
+	return models, nil
+}
+
 // cloudflareAPIPayload matches the structure for the Cloudflare API.
+// Image and Mask are sent as arrays of byte values, which is what the
+// Workers AI image-to-image models expect (a JSON []byte would be base64).
 type cloudflareAPIPayload struct {
-	Prompt string `json:"prompt"`
-	Steps  int    `json:"steps,omitempty"`
-	Width  int    `json:"width,omitempty"`
-	Height int    `json:"height,omitempty"`
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Steps          int     `json:"steps,omitempty"`
+	NumSteps       int     `json:"num_steps,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	Guidance       float64 `json:"guidance,omitempty"`
+	Strength       float64 `json:"strength,omitempty"`
+	Seed           int64   `json:"seed,omitempty"`
+	Image          []int   `json:"image,omitempty"`
+	Mask           []int   `json:"mask,omitempty"`
+}
+
+// byteValues converts raw bytes to the integer array form used by Cloudflare.
+func byteValues(data []byte) []int {
+	values := make([]int, len(data))
+	for i, b := range data {
+		values[i] = int(b)
+	}
+	return values
 }
 
 // cloudflareImageResponse matches the JSON response with base64 image data.
@@ -80,46 +164,64 @@ type cloudflareImageResponse struct {
 }
 
 // Generate sends a request to the Cloudflare API.
-func (p *CloudflareProvider) Generate(input GenerationInput) (*GenerationOutput, error) {
+func (p *CloudflareProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
 	payload := cloudflareAPIPayload{
 		Prompt: input.Prompt,
 	}
 
-	var modelCaps ModelCapabilities
-	found := false
-	for _, m := range p.GetModels() {
-		if m.Name == input.Model {
-			modelCaps = m
-			found = true
-			break
-		}
-	}
-
+	modelCaps, found := FindModel(p, input.Model)
 	if !found {
 		return nil, fmt.Errorf("cloudflare: model %s not found or not supported", input.Model)
 	}
 
-	// Helper to check if a parameter is supported by the current model
-	isParamSupported := func(param string) bool {
-		for _, supportedParam := range modelCaps.SupportedParams {
-			if supportedParam == param {
-				return true
-			}
+	if modelCaps.Supports("steps") {
+		if cloudflareNumStepsModels[input.Model] {
+			payload.NumSteps = input.Steps
+		} else {
+			payload.Steps = input.Steps
 		}
-		return false
 	}
-
-	if isParamSupported("steps") {
-		payload.Steps = input.Steps
-	}
-	if isParamSupported("width") {
+	if modelCaps.Supports("width") {
 		payload.Width = input.Width
 	}
-	if isParamSupported("height") {
+	if modelCaps.Supports("height") {
 		payload.Height = input.Height
 	}
+	if modelCaps.Supports("negative_prompt") {
+		payload.NegativePrompt = input.NegativePrompt
+	}
+	if modelCaps.Supports("guidance") {
+		payload.Guidance = input.Guidance
+		if payload.Guidance == 0 {
+			payload.Guidance = modelCaps.ParamDefault("guidance")
+		}
+	}
+	if modelCaps.Supports("strength") {
+		payload.Strength = input.Strength
+		if payload.Strength == 0 {
+			payload.Strength = modelCaps.ParamDefault("strength")
+		}
+	}
+	if modelCaps.Supports("seed") {
+		payload.Seed = input.Seed
+	}
+	if modelCaps.AcceptsImage() {
+		if len(input.ImageBytes) == 0 {
+			return nil, fmt.Errorf("cloudflare: model %s requires an input image", input.Model)
+		}
+		payload.Image = byteValues(input.ImageBytes)
+	}
+	if modelCaps.HasTask(TaskInpaint) {
+		if len(input.MaskBytes) == 0 {
+			return nil, fmt.Errorf("cloudflare: model %s requires a mask", input.Model)
+		}
+		payload.Mask = byteValues(input.MaskBytes)
+	}
 
-	logPayloadBytes, _ := json.MarshalIndent(payload, "", "  ")
+	// Leave the image data out of the log, it is far too large to be useful.
+	logPayload := payload
+	logPayload.Image, logPayload.Mask = nil, nil
+	logPayloadBytes, _ := json.MarshalIndent(logPayload, "", "  ")
 	log.Printf("Calling provider '%s' with model '%s'", p.GetName(), input.Model)
 	log.Printf("Request payload: \n%s", string(logPayloadBytes))
 
@@ -129,7 +231,7 @@ func (p *CloudflareProvider) Generate(input GenerationInput) (*GenerationOutput,
 	}
 
 	apiURL := fmt.Sprintf(cloudflareAPIURLFormat, p.AccountID, input.Model)
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("cloudflare: failed to create request: %w", err)
 	}
@@ -145,7 +247,7 @@ func (p *CloudflareProvider) Generate(input GenerationInput) (*GenerationOutput,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("cloudflare: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp.StatusCode, "cloudflare: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -176,6 +278,6 @@ func (p *CloudflareProvider) Generate(input GenerationInput) (*GenerationOutput,
 	}
 
 	return &GenerationOutput{
-		ImageBytes: imageData,
+		Images: []GeneratedImage{{Bytes: imageData}},
 	}, nil
 }
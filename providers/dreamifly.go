@@ -2,6 +2,7 @@ package providers
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,31 +10,36 @@ import (
 	"log"
 	"net/http"
 	"strings"
-)
 
-const (
-	dreamiflyAPIURL               = "https://dreamifly.com/api/generate"
-	dreamiflyOptimizePromptAPIURL = "https://dreamifly.com/api/optimize-prompt"
+	"imageapi/httpclient"
 )
 
+const dreamiflyAPIURL = "https://dreamifly.com/api/generate"
+
 // DreamiflyProvider implements the ImageProvider for Dreamifly.
 type DreamiflyProvider struct {
 	Client *http.Client
 }
 
+// dreamiflyEditRanges applies to the image-to-image models, where "strength"
+// is sent upstream as "denoise".
+var dreamiflyEditRanges = map[string]ParamRange{
+	"strength": {Min: 0.5, Max: 1.0, Default: 0.7},
+}
+
 var dreamiflyModels = []ModelCapabilities{
-	{Name: "Flux-Kontext", SupportedParams: []string{"steps", "seed", "image"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
-	{Name: "Qwen-Image-Edit", SupportedParams: []string{"steps", "seed", "image"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
-	{Name: "Wai-SDXL-V150", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
-	{Name: "Flux-Krea", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
-	{Name: "HiDream-full-fp8", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
-	{Name: "Qwen-Image", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25},
+	{Name: "Flux-Kontext", SupportedParams: []string{"steps", "seed", "strength"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportBytes, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8, ParamRanges: dreamiflyEditRanges, EnglishPrompt: true},
+	{Name: "Qwen-Image-Edit", SupportedParams: []string{"steps", "seed", "strength"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportBytes, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8, ParamRanges: dreamiflyEditRanges},
+	{Name: "Wai-SDXL-V150", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8, EnglishPrompt: true},
+	{Name: "Flux-Krea", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8, EnglishPrompt: true},
+	{Name: "HiDream-full-fp8", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8},
+	{Name: "Qwen-Image", SupportedParams: []string{"steps", "seed"}, MaxWidth: 1920, MaxHeight: 1920, MinSteps: 5, MaxSteps: 40, DefaultSteps: 25, MaxBatch: 4, SizeMultiple: 8},
 }
 
 // NewDreamiflyProvider creates a new Dreamifly client.
 func NewDreamiflyProvider() *DreamiflyProvider {
 	return &DreamiflyProvider{
-		Client: &http.Client{},
+		Client: httpclient.New("Dreamifly"),
 	}
 }
 
@@ -42,11 +48,6 @@ func (p *DreamiflyProvider) GetName() string {
 	return "Dreamifly"
 }
 
-// RequiresImageURL returns false as Dreamifly accepts image bytes directly.
-func (p *DreamiflyProvider) RequiresImageURL() bool {
-	return false
-}
-
 // GetModels returns the list of models and their capabilities for Dreamifly.
 func (p *DreamiflyProvider) GetModels() []ModelCapabilities {
 	return dreamiflyModels
@@ -66,66 +67,14 @@ type dreamiflyAPIPayload struct {
 }
 
 // ImageResponse matches the JSON response with base64 image data.
+// Batched requests return every image in ImageURLs.
 type dreamiflyImageResponse struct {
-	ImageURL string `json:"imageUrl"`
-}
-
-// OptimizePrompt sends a request to the Dreamifly API to optimize a prompt.
-func (p *DreamiflyProvider) OptimizePrompt(prompt string) (string, error) {
-	payload := struct {
-		Prompt string `json:"prompt"`
-	}{
-		Prompt: prompt,
-	}
-
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return "", fmt.Errorf("dreamifly: failed to marshal optimize prompt payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", dreamiflyOptimizePromptAPIURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return "", fmt.Errorf("dreamifly: failed to create optimize prompt request: %w", err)
-	}
-
-	// Set headers from API doc
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Origin", "https://dreamifly.com")
-	req.Header.Set("Referer", "https://dreamifly.com/zh")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36")
-
-	log.Printf("Calling Dreamifly prompt optimization for: \"%s\"", prompt)
-
-	resp, err := p.Client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("dreamifly: failed to call optimize prompt API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("dreamifly: optimize prompt API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
-	}
-
-	var optimizeResp struct {
-		Success         bool   `json:"success"`
-		OriginalPrompt  string `json:"originalPrompt"`
-		OptimizedPrompt string `json:"optimizedPrompt"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&optimizeResp); err != nil {
-		return "", fmt.Errorf("dreamifly: failed to decode optimize prompt response: %w", err)
-	}
-
-	if !optimizeResp.Success {
-		return "", fmt.Errorf("dreamifly: optimize prompt API reported failure")
-	}
-
-	return optimizeResp.OptimizedPrompt, nil
+	ImageURL  string   `json:"imageUrl"`
+	ImageURLs []string `json:"imageUrls"`
 }
 
 // Generate sends a request to the Dreamifly API.
-func (p *DreamiflyProvider) Generate(input GenerationInput) (*GenerationOutput, error) {
+func (p *DreamiflyProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
 	images := make([]string, 0)
 	if len(input.ImageBytes) > 0 {
 		encodedImage := base64.StdEncoding.EncodeToString(input.ImageBytes)
@@ -137,20 +86,28 @@ func (p *DreamiflyProvider) Generate(input GenerationInput) (*GenerationOutput,
 		steps = 25 // A reasonable default if not provided
 	}
 
+	batchSize := input.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
 	payload := dreamiflyAPIPayload{
 		Prompt:    input.Prompt,
 		Width:     input.Width,
 		Height:    input.Height,
 		Steps:     steps,
 		Seed:      input.Seed,
-		BatchSize: 1,
+		BatchSize: batchSize,
 		Model:     input.Model,
 		Images:    images,
 	}
 
 	// Denoise is only applicable for image-to-image operations.
 	if len(images) > 0 {
-		payload.Denoise = 0.7
+		payload.Denoise = input.Strength
+		if payload.Denoise == 0 {
+			payload.Denoise = dreamiflyEditRanges["strength"].Default
+		}
 	}
 
 	// Create a copy of the payload for logging, but without the image data.
@@ -172,7 +129,7 @@ func (p *DreamiflyProvider) Generate(input GenerationInput) (*GenerationOutput,
 		return nil, fmt.Errorf("dreamifly: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", dreamiflyAPIURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", dreamiflyAPIURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("dreamifly: failed to create request: %w", err)
 	}
@@ -190,7 +147,7 @@ func (p *DreamiflyProvider) Generate(input GenerationInput) (*GenerationOutput,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("dreamifly: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp.StatusCode, "dreamifly: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -199,31 +156,42 @@ func (p *DreamiflyProvider) Generate(input GenerationInput) (*GenerationOutput,
 	}
 
 	var imageResp dreamiflyImageResponse
-	var imageData []byte
-
-	if err := json.Unmarshal(respBody, &imageResp); err == nil && imageResp.ImageURL != "" {
-		// It's a JSON response with a data URL
-		dataURL := imageResp.ImageURL
-		if strings.HasPrefix(dataURL, "data:image/") {
-			commaIndex := strings.Index(dataURL, ",")
-			if commaIndex != -1 {
-				base64Data := dataURL[commaIndex+1:]
-				imageData, err = base64.StdEncoding.DecodeString(base64Data)
-				if err != nil {
-					return nil, fmt.Errorf("dreamifly: failed to decode base64 image data: %w", err)
-				}
-			} else {
-				return nil, fmt.Errorf("dreamifly: invalid data URL format")
-			}
-		} else {
-			return nil, fmt.Errorf("dreamifly: unexpected image URL format")
-		}
-	} else {
+	if err := json.Unmarshal(respBody, &imageResp); err != nil || (imageResp.ImageURL == "" && len(imageResp.ImageURLs) == 0) {
 		// Treat the raw response as image data
-		imageData = respBody
+		return &GenerationOutput{
+			Images: []GeneratedImage{{Bytes: respBody}},
+		}, nil
+	}
+
+	// It's a JSON response with one data URL, or several for batched requests.
+	dataURLs := imageResp.ImageURLs
+	if len(dataURLs) == 0 {
+		dataURLs = []string{imageResp.ImageURL}
+	}
+
+	output := &GenerationOutput{}
+	for _, dataURL := range dataURLs {
+		imageData, err := decodeDreamiflyDataURL(dataURL)
+		if err != nil {
+			return nil, err
+		}
+		output.Images = append(output.Images, GeneratedImage{Bytes: imageData})
 	}
+	return output, nil
+}
 
-	return &GenerationOutput{
-		ImageBytes: imageData,
-	}, nil
+// decodeDreamiflyDataURL extracts the image bytes from a base64 data URL.
+func decodeDreamiflyDataURL(dataURL string) ([]byte, error) {
+	if !strings.HasPrefix(dataURL, "data:image/") {
+		return nil, fmt.Errorf("dreamifly: unexpected image URL format")
+	}
+	commaIndex := strings.Index(dataURL, ",")
+	if commaIndex == -1 {
+		return nil, fmt.Errorf("dreamifly: invalid data URL format")
+	}
+	imageData, err := base64.StdEncoding.DecodeString(dataURL[commaIndex+1:])
+	if err != nil {
+		return nil, fmt.Errorf("dreamifly: failed to decode base64 image data: %w", err)
+	}
+	return imageData, nil
 }
@@ -2,31 +2,98 @@ package providers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"time"
+
+	"imageapi/httpclient"
+)
+
+const (
+	falAIQueueURL        = "https://queue.fal.run/"
+	falAIPollingInterval = 2 * time.Second
+	falAIMaxPolls        = 300 // 10 minutes; the generation deadline usually ends polling first
 )
 
-const falAIAPIURL = "https://fal.run/fal-ai/bytedance/seedream/v4/edit"
+// Values of FalModel.ImageSize.
+const (
+	FalImageSizeObject = "object" // {"width": W, "height": H}
+	FalImageSizePreset = "preset" // The closest named preset, e.g. "landscape_4_3"
+)
+
+// FalModel maps a model to its Fal.ai endpoint and describes how the request
+// parameters are sent to it.
+type FalModel struct {
+	Capabilities ModelCapabilities
+	// Endpoint is the Fal model path, e.g. "fal-ai/flux/schnell".
+	Endpoint string
+	// ImageSize is FalImageSizeObject (the default) or FalImageSizePreset.
+	ImageSize string
+	// ImageField is the field the input image URL is sent in: "image_urls"
+	// (a list, the default) or "image_url".
+	ImageField string
+	// SafetyChecker is sent as enable_safety_checker when set.
+	SafetyChecker *bool
+	// ExtraParams are added to every request, below the mapped parameters.
+	ExtraParams map[string]interface{}
+}
 
-// FalAIProvider implements the ImageProvider for Fal.ai.
+// FalAIProvider implements the ImageProvider for Fal.ai. Requests go through
+// the queue API: the job is submitted, its status polled, and the result
+// fetched once it completes. A job is canceled upstream when ctx ends first.
 type FalAIProvider struct {
-	APIKey string
-	Client *http.Client
+	APIKey   string
+	Client   *http.Client
+	QueueURL string // Base URL of the queue API, with a trailing slash
+
+	models       map[string]FalModel
+	capabilities []ModelCapabilities // In declaration order
 }
 
-var falAIModels = []ModelCapabilities{
-	{Name: "bytedance/seedream/v4/edit", SupportedParams: []string{"seed", "image"}, MaxWidth: 4096, MaxHeight: 4096},
+var falSafetyCheckerOff = false
+
+var falAIModels = []FalModel{
+	{
+		Capabilities:  ModelCapabilities{Name: "bytedance/seedream/v4/edit", SupportedParams: []string{"seed"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportURL, MaxWidth: 4096, MaxHeight: 4096, MaxBatch: 4},
+		Endpoint:      "fal-ai/bytedance/seedream/v4/edit",
+		SafetyChecker: &falSafetyCheckerOff,
+	},
+	{
+		Capabilities:  ModelCapabilities{Name: "bytedance/seedream/v4/text-to-image", SupportedParams: []string{"seed"}, MaxWidth: 4096, MaxHeight: 4096, MaxBatch: 4},
+		Endpoint:      "fal-ai/bytedance/seedream/v4/text-to-image",
+		SafetyChecker: &falSafetyCheckerOff,
+	},
 }
 
-// NewFalAIProvider creates a new Fal.ai client.
-func NewFalAIProvider(apiKey string) *FalAIProvider {
-	return &FalAIProvider{
-		APIKey: apiKey,
-		Client: &http.Client{},
+// NewFalAIProvider creates a new Fal.ai client serving the built-in models
+// plus extra, which replace built-in models of the same name.
+func NewFalAIProvider(apiKey string, extra []FalModel) *FalAIProvider {
+	p := &FalAIProvider{
+		APIKey:   apiKey,
+		Client:   httpclient.New("Fal_ai"),
+		QueueURL: falAIQueueURL,
+		models:   make(map[string]FalModel),
+	}
+	index := make(map[string]int)
+	for _, m := range append(append([]FalModel(nil), falAIModels...), extra...) {
+		name := m.Capabilities.Name
+		if m.Endpoint == "" {
+			m.Endpoint = "fal-ai/" + name
+		}
+		if i, exists := index[name]; exists {
+			p.capabilities[i] = m.Capabilities
+		} else {
+			index[name] = len(p.capabilities)
+			p.capabilities = append(p.capabilities, m.Capabilities)
+		}
+		p.models[name] = m
 	}
+	return p
 }
 
 // GetName returns the name of the provider.
@@ -34,77 +101,42 @@ func (p *FalAIProvider) GetName() string {
 	return "Fal_ai"
 }
 
-// RequiresImageURL returns true as Fal.ai requires an image URL.
-func (p *FalAIProvider) RequiresImageURL() bool {
-	return true
-}
-
 // GetModels returns the list of models and their capabilities for Fal.ai.
 func (p *FalAIProvider) GetModels() []ModelCapabilities {
-	return falAIModels
+	return p.capabilities
 }
 
-type falAIAPIPayload struct {
-	Prompt    string   `json:"prompt"`
-	ImageURLs []string `json:"image_urls"`
-	ImageSize struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"image_size"`
-	EnableSafetyChecker bool `json:"enable_safety_checker"`
+// falAIQueueResponse is returned when a job is submitted to the queue.
+type falAIQueueResponse struct {
+	RequestID   string `json:"request_id"`
+	StatusURL   string `json:"status_url"`
+	ResponseURL string `json:"response_url"`
+	CancelURL   string `json:"cancel_url"`
+}
+
+type falAIStatusResponse struct {
+	Status        string `json:"status"` // IN_QUEUE, IN_PROGRESS or COMPLETED
+	QueuePosition int    `json:"queue_position"`
 }
 
 type falAIAPIResponse struct {
 	Images []struct {
 		URL string `json:"url"`
 	} `json:"images"`
+	Seed int64 `json:"seed"`
 }
 
-// Generate sends a request to the Fal.ai API.
-// Note: Fal.ai requires an image URL, so the controller logic
-// will need to ensure input.ImageURL is populated.
-func (p *FalAIProvider) Generate(input GenerationInput) (*GenerationOutput, error) {
-	// Dynamically check if the selected model requires an image.
-	var modelInfo ModelCapabilities
-	found := false
-	for _, m := range falAIModels {
-		if m.Name == input.Model {
-			modelInfo = m
-			found = true
-			break
-		}
-	}
-
-	// This should ideally not be reached if validation happens upstream.
+// Generate submits the job to the Fal.ai queue, waits for it and downloads
+// the images. Fal.ai takes input images by URL; the central request path
+// uploads the image and enforces the model's image requirement.
+func (p *FalAIProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
+	model, found := p.models[input.Model]
 	if !found {
 		return nil, fmt.Errorf("Fal_ai: model '%s' not found in provider capabilities", input.Model)
 	}
+	modelCaps, _ := FindModel(p, input.Model)
 
-	modelNeedsImage := false
-	for _, param := range modelInfo.SupportedParams {
-		if param == "image" {
-			modelNeedsImage = true
-			break
-		}
-	}
-
-	if input.ImageURL == "" && modelNeedsImage {
-		return nil, fmt.Errorf("Fal_ai: image URL is required for model '%s'", input.Model)
-	}
-
-	imageURLs := make([]string, 0)
-	if input.ImageURL != "" {
-		imageURLs = append(imageURLs, input.ImageURL)
-	}
-
-	payload := falAIAPIPayload{
-		Prompt:              input.Prompt,
-		ImageURLs:           imageURLs,
-		EnableSafetyChecker: false,
-	}
-	payload.ImageSize.Width = input.Width
-	payload.ImageSize.Height = input.Height
-
+	payload := falPayload(model, modelCaps, input)
 	logPayloadBytes, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		log.Printf("Error marshalling log payload: %v", err)
@@ -118,42 +150,196 @@ func (p *FalAIProvider) Generate(input GenerationInput) (*GenerationOutput, erro
 		return nil, fmt.Errorf("Fal_ai: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", falAIAPIURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, fmt.Errorf("Fal_ai: failed to create request: %w", err)
+	// 1. Submit the job to the queue
+	var queued falAIQueueResponse
+	if err := p.call(ctx, "POST", p.QueueURL+model.Endpoint, payloadBytes, &queued); err != nil {
+		return nil, fmt.Errorf("Fal_ai: failed to submit job: %w", err)
+	}
+	if queued.RequestID == "" || queued.StatusURL == "" || queued.ResponseURL == "" {
+		return nil, fmt.Errorf("Fal_ai: queue did not return a request ID and URLs")
 	}
+	log.Printf("Fal_ai: job submitted successfully, request_id: %s", queued.RequestID)
 
-	req.Header.Set("Content-Type", "application/json")
+	// 2. Poll the status until the job completes, canceling it if we give up
+	if err := p.waitForJob(ctx, queued); err != nil {
+		if queued.CancelURL != "" {
+			p.cancelJob(queued)
+		}
+		return nil, err
+	}
+
+	// 3. Fetch the result
+	var apiResp falAIAPIResponse
+	if err := p.call(ctx, "GET", queued.ResponseURL, nil, &apiResp); err != nil {
+		return nil, fmt.Errorf("Fal_ai: failed to fetch result: %w", err)
+	}
+	if len(apiResp.Images) == 0 {
+		return nil, fmt.Errorf("Fal_ai: no images returned in response")
+	}
+
+	// The response from Fal.ai contains URLs. Download the image bytes.
+	// The seed is the batch's base seed, which only reproduces an image
+	// generated on its own.
+	output := &GenerationOutput{}
+	for _, image := range apiResp.Images {
+		imageData, _, err := DownloadFile(ctx, image.URL)
+		if err != nil {
+			return nil, fmt.Errorf("Fal_ai: failed to download generated image: %w", err)
+		}
+		generated := GeneratedImage{Bytes: imageData}
+		if len(apiResp.Images) == 1 {
+			generated.Seed = apiResp.Seed
+		}
+		output.Images = append(output.Images, generated)
+	}
+	return output, nil
+}
+
+// waitForJob polls the status of a queued job until it completes.
+func (p *FalAIProvider) waitForJob(ctx context.Context, queued falAIQueueResponse) error {
+	for i := 0; i < falAIMaxPolls; i++ {
+		if err := sleepContext(ctx, falAIPollingInterval); err != nil {
+			return fmt.Errorf("Fal_ai: stopped polling job %s: %w", queued.RequestID, err)
+		}
+
+		var status falAIStatusResponse
+		if err := p.call(ctx, "GET", queued.StatusURL, nil, &status); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("Fal_ai: stopped polling job %s: %w", queued.RequestID, ctx.Err())
+			}
+			// The job might still be running; the status endpoint can fail transiently.
+			log.Printf("Fal_ai: polling job %s failed: %v", queued.RequestID, err)
+			continue
+		}
+
+		switch status.Status {
+		case "COMPLETED":
+			return nil
+		case "IN_QUEUE":
+			log.Printf("Fal_ai: job %s is queued at position %d", queued.RequestID, status.QueuePosition)
+		case "IN_PROGRESS":
+		default:
+			return fmt.Errorf("Fal_ai: job %s has unexpected status '%s'", queued.RequestID, status.Status)
+		}
+	}
+	return fmt.Errorf("Fal_ai: polling timed out after %d attempts", falAIMaxPolls)
+}
+
+// cancelJob asks Fal.ai to drop a job nobody waits for anymore.
+func (p *FalAIProvider) cancelJob(queued falAIQueueResponse) {
+	// Use a fresh context: the request context may already be done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.call(ctx, "PUT", queued.CancelURL, nil, nil); err != nil {
+		log.Printf("Warning: failed to cancel Fal_ai job %s: %v", queued.RequestID, err)
+		return
+	}
+	log.Printf("Fal_ai: canceled job %s", queued.RequestID)
+}
+
+// call sends an authenticated request to the queue API and decodes the JSON
+// response into out, if out is not nil.
+func (p *FalAIProvider) call(ctx context.Context, method, url string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	req.Header.Set("Authorization", "Key "+p.APIKey)
 
 	resp, err := p.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Fal_ai: failed to call external API: %w", err)
+		return fmt.Errorf("failed to call external API: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Fal_ai: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp.StatusCode, "API returned non-2xx status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
 	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
 
-	var apiResp falAIAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("Fal_ai: failed to decode response: %w", err)
+// falPayload builds the request body of model for input.
+func falPayload(model FalModel, caps ModelCapabilities, input GenerationInput) map[string]interface{} {
+	payload := make(map[string]interface{}, len(model.ExtraParams)+8)
+	for k, v := range model.ExtraParams {
+		payload[k] = v
 	}
 
-	if len(apiResp.Images) == 0 {
-		return nil, fmt.Errorf("Fal_ai: no images returned in response")
+	payload["prompt"] = input.Prompt
+	if model.ImageSize == FalImageSizePreset {
+		payload["image_size"] = falImageSizePreset(input.Width, input.Height)
+	} else {
+		payload["image_size"] = map[string]int{"width": input.Width, "height": input.Height}
+	}
+	if input.BatchSize > 0 {
+		payload["num_images"] = input.BatchSize
+	}
+	if model.SafetyChecker != nil {
+		payload["enable_safety_checker"] = *model.SafetyChecker
 	}
 
-	// The response from Fal.ai is a URL. Download the image bytes.
-	imageURL := apiResp.Images[0].URL
-	imageData, _, err := DownloadFile(imageURL)
-	if err != nil {
-		return nil, fmt.Errorf("Fal_ai: failed to download generated image: %w", err)
+	if caps.Supports("seed") {
+		payload["seed"] = input.Seed
+	}
+	if caps.Supports("negative_prompt") && input.NegativePrompt != "" {
+		payload["negative_prompt"] = input.NegativePrompt
+	}
+	if caps.Supports("steps") && input.Steps > 0 {
+		payload["num_inference_steps"] = input.Steps
+	}
+	if caps.Supports("guidance") && input.Guidance > 0 {
+		payload["guidance_scale"] = input.Guidance
 	}
+	if caps.Supports("strength") && input.Strength > 0 {
+		payload["strength"] = input.Strength
+	}
+
+	if input.ImageURL != "" {
+		if model.ImageField == "image_url" {
+			payload["image_url"] = input.ImageURL
+		} else {
+			payload["image_urls"] = []string{input.ImageURL}
+		}
+	}
+	return payload
+}
+
+// falImageSizePresets are the named sizes Fal.ai models accept in image_size.
+var falImageSizePresets = []struct {
+	name          string
+	width, height int
+}{
+	{"square_hd", 1024, 1024},
+	{"square", 512, 512},
+	{"portrait_4_3", 768, 1024},
+	{"portrait_16_9", 576, 1024},
+	{"landscape_4_3", 1024, 768},
+	{"landscape_16_9", 1024, 576},
+}
 
-	return &GenerationOutput{
-		ImageBytes: imageData,
-	}, nil
+// falImageSizePreset returns the preset closest to width x height in aspect
+// ratio, preferring the one closest in size among equally shaped presets.
+func falImageSizePreset(width, height int) string {
+	best, bestScore := "square_hd", math.Inf(1)
+	for _, preset := range falImageSizePresets {
+		ratio := math.Abs(math.Log(float64(width)/float64(height)) - math.Log(float64(preset.width)/float64(preset.height)))
+		size := math.Abs(float64(width*height-preset.width*preset.height)) / float64(preset.width*preset.height)
+		if score := ratio*10 + size; score < bestScore {
+			best, bestScore = preset.name, score
+		}
+	}
+	return best
 }
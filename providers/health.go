@@ -0,0 +1,265 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the upstream while the circuit
+// breaker of a provider is open.
+var ErrCircuitOpen = errors.New("provider is temporarily unavailable after repeated failures")
+
+// CircuitState is the state of a provider's circuit breaker.
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // Requests go through
+	CircuitOpen     CircuitState = "open"      // Requests fail fast until the cooldown ends
+	CircuitHalfOpen CircuitState = "half_open" // A few probe requests decide whether to close again
+)
+
+// Overall health reported for a provider.
+const (
+	HealthHealthy     = "healthy"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// degradedSuccessRate is the rolling success rate below which a provider
+// with a closed circuit is reported as degraded.
+const degradedSuccessRate = 0.8
+
+// BreakerSettings configures the circuit breaker of a HealthTrackedProvider.
+type BreakerSettings struct {
+	FailureThreshold int           // Consecutive failures that open the circuit
+	OpenDuration     time.Duration // How long the circuit stays open before probing
+	HalfOpenProbes   int           // Concurrent probe requests allowed while half-open
+	WindowSize       int           // Number of recent calls kept for the statistics
+}
+
+// outcome is one call recorded in the rolling window.
+type outcome struct {
+	ok      bool
+	latency time.Duration
+}
+
+// HealthTrackedProvider wraps an ImageProvider with rolling success and
+// latency statistics and a circuit breaker. After FailureThreshold consecutive
+// failures it fails fast with ErrCircuitOpen; once OpenDuration has passed,
+// up to HalfOpenProbes requests are let through, and the first result decides
+// whether the circuit closes or opens again.
+type HealthTrackedProvider struct {
+	ImageProvider
+	settings BreakerSettings
+
+	mu                  sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	openedAt            time.Time
+	probes              int // Probe requests in flight while half-open
+	window              []outcome
+	next                int // Next slot of window to overwrite once it is full
+	totalRequests       int64
+	totalFailures       int64
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+// NewHealthTrackedProvider wraps provider. Zero settings fall back to
+// 5 failures, 60 seconds, 1 probe and a window of 50 calls.
+func NewHealthTrackedProvider(provider ImageProvider, settings BreakerSettings) *HealthTrackedProvider {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenDuration <= 0 {
+		settings.OpenDuration = 60 * time.Second
+	}
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+	if settings.WindowSize <= 0 {
+		settings.WindowSize = 50
+	}
+	return &HealthTrackedProvider{
+		ImageProvider: provider,
+		settings:      settings,
+		state:         CircuitClosed,
+	}
+}
+
+// Unwrap returns the wrapped provider.
+func (p *HealthTrackedProvider) Unwrap() ImageProvider {
+	return p.ImageProvider
+}
+
+// Generate calls the wrapped provider unless the circuit is open, and records
+// the outcome. Calls abandoned because the client went away are not counted,
+// and only errors that isUpstreamFailure accepts count as failures.
+func (p *HealthTrackedProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
+	probe, err := p.acquire()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.GetName(), err)
+	}
+
+	start := time.Now()
+	output, err := p.ImageProvider.Generate(ctx, input)
+	p.record(probe, time.Since(start), err, errors.Is(ctx.Err(), context.Canceled))
+	return output, err
+}
+
+// acquire decides whether a call may go through, moving an open circuit to
+// half-open once the cooldown has passed. probe is true for half-open probes.
+func (p *HealthTrackedProvider) acquire() (probe bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == CircuitOpen {
+		if time.Since(p.openedAt) < p.settings.OpenDuration {
+			return false, ErrCircuitOpen
+		}
+		p.state = CircuitHalfOpen
+		p.probes = 0
+		log.Printf("Circuit breaker of provider '%s' is half-open, probing upstream", p.GetName())
+	}
+	if p.state == CircuitHalfOpen {
+		if p.probes >= p.settings.HalfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		p.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record updates the statistics and the circuit state with one call's result.
+func (p *HealthTrackedProvider) record(probe bool, latency time.Duration, err error, canceled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if probe {
+		p.probes--
+	}
+	if err != nil && canceled {
+		return
+	}
+
+	// Anything else, such as a 400 for a prompt the upstream rejects, means
+	// the upstream answered and is as healthy as after a success.
+	failed := err != nil && isUpstreamFailure(err)
+
+	p.totalRequests++
+	result := outcome{ok: !failed, latency: latency}
+	if len(p.window) < p.settings.WindowSize {
+		p.window = append(p.window, result)
+	} else {
+		p.window[p.next] = result
+		p.next = (p.next + 1) % p.settings.WindowSize
+	}
+
+	if !failed {
+		p.consecutiveFailures = 0
+		if p.state == CircuitHalfOpen {
+			p.state = CircuitClosed
+			log.Printf("Circuit breaker of provider '%s' closed, upstream recovered", p.GetName())
+		}
+		return
+	}
+
+	p.totalFailures++
+	p.consecutiveFailures++
+	p.lastError = err.Error()
+	p.lastErrorAt = time.Now()
+	if p.state == CircuitHalfOpen || p.consecutiveFailures >= p.settings.FailureThreshold {
+		if p.state != CircuitOpen {
+			log.Printf("Circuit breaker of provider '%s' opened after %d consecutive failure(s), failing fast for %v", p.GetName(), p.consecutiveFailures, p.settings.OpenDuration)
+		}
+		p.state = CircuitOpen
+		p.openedAt = time.Now()
+	}
+}
+
+// isUpstreamFailure reports whether err means the upstream is unhealthy: a
+// transport error, a timeout, a 5xx status or a 429.
+func isUpstreamFailure(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// ProviderStatus is a snapshot of a provider's health.
+type ProviderStatus struct {
+	Provider            string       `json:"provider"`
+	Status              string       `json:"status"` // healthy, degraded or unavailable
+	CircuitState        CircuitState `json:"circuit_state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	// The following describe the rolling window of recent calls.
+	RecentRequests   int      `json:"recent_requests"`
+	SuccessRate      *float64 `json:"success_rate,omitempty"`
+	AverageLatencyMs int64    `json:"average_latency_ms,omitempty"`
+
+	TotalRequests int64      `json:"total_requests"`
+	TotalFailures int64      `json:"total_failures"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"` // When an open circuit starts probing
+}
+
+// Status returns the current health of the provider.
+func (p *HealthTrackedProvider) Status() ProviderStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := ProviderStatus{
+		Provider:            p.GetName(),
+		CircuitState:        p.state,
+		ConsecutiveFailures: p.consecutiveFailures,
+		RecentRequests:      len(p.window),
+		TotalRequests:       p.totalRequests,
+		TotalFailures:       p.totalFailures,
+		LastError:           p.lastError,
+	}
+	if !p.lastErrorAt.IsZero() {
+		lastErrorAt := p.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+
+	if len(p.window) > 0 {
+		var successes int
+		var latency time.Duration
+		for _, o := range p.window {
+			if o.ok {
+				successes++
+			}
+			latency += o.latency
+		}
+		rate := float64(successes) / float64(len(p.window))
+		status.SuccessRate = &rate
+		status.AverageLatencyMs = (latency / time.Duration(len(p.window))).Milliseconds()
+	}
+
+	if p.state == CircuitOpen {
+		retryAt := p.openedAt.Add(p.settings.OpenDuration)
+		status.RetryAt = &retryAt
+	}
+	switch {
+	case status.RetryAt != nil && time.Now().Before(*status.RetryAt):
+		status.Status = HealthUnavailable
+	case p.state != CircuitClosed, status.SuccessRate != nil && *status.SuccessRate < degradedSuccessRate:
+		// An open circuit past its cooldown probes with the next request.
+		status.Status = HealthDegraded
+	default:
+		status.Status = HealthHealthy
+	}
+	return status
+}
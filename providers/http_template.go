@@ -0,0 +1,426 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"imageapi/config"
+	"imageapi/httpclient"
+)
+
+const (
+	defaultTemplatePollInterval    = 5 * time.Second
+	defaultTemplatePollMaxAttempts = 60
+)
+
+// Image extraction rules of a template provider response.
+const (
+	responseRaw     = "raw"      // The response body is the image
+	responseBase64  = "base64"   // A JSON value holds base64 image data
+	responseDataURL = "data_url" // A JSON value holds a data: URL
+	responseURL     = "url"      // A JSON value holds the URL to download the image from
+)
+
+// HTTPTemplateProvider implements the ImageProvider for upstreams described
+// entirely in the HTTP_PROVIDERS section of conf.json: a templated request,
+// a rule to extract the image from the response and, optionally, a polling
+// loop for asynchronous APIs.
+type HTTPTemplateProvider struct {
+	Name   string
+	Models []ModelCapabilities
+	Client *http.Client
+
+	request      *requestTemplate
+	response     config.HTTPResponseRule
+	poll         *config.HTTPPollSettings
+	pollRequest  *requestTemplate
+	pollInterval time.Duration
+}
+
+// httpTemplateData is what request templates are evaluated over. All fields
+// of GenerationInput are available, e.g. {{.Prompt}} or {{.Width}}.
+type httpTemplateData struct {
+	GenerationInput
+	ImageBase64 string // Input image, base64 encoded
+	MaskBase64  string // Inpainting mask, base64 encoded
+	TaskID      string // Task ID from the submit response, for the poll request
+}
+
+// templateFuncs are available in every request template. "json" encodes a
+// value as a JSON literal, which keeps prompts with quotes valid inside BODY.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"env": os.Getenv,
+}
+
+// requestTemplate is a parsed config.HTTPRequestTemplate.
+type requestTemplate struct {
+	method  string
+	url     *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+}
+
+// NewHTTPTemplateProvider parses the templates of settings and creates the
+// provider. Errors describe the first invalid part of the configuration.
+func NewHTTPTemplateProvider(settings config.HTTPProviderSettings, models []ModelCapabilities) (*HTTPTemplateProvider, error) {
+	p := &HTTPTemplateProvider{
+		Name:     settings.Name,
+		Models:   models,
+		Client:   httpclient.New(settings.Name),
+		response: settings.Response,
+	}
+
+	var err error
+	if p.request, err = parseRequestTemplate("REQUEST", settings.Request); err != nil {
+		return nil, err
+	}
+
+	switch settings.Response.Type {
+	case responseRaw:
+		if settings.Poll != nil {
+			return nil, fmt.Errorf("RESPONSE.TYPE 'raw' cannot be combined with POLL")
+		}
+	case responseBase64, responseDataURL, responseURL:
+		if settings.Response.Path == "" {
+			return nil, fmt.Errorf("RESPONSE.PATH is required for RESPONSE.TYPE '%s'", settings.Response.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown RESPONSE.TYPE '%s', expected raw, base64, data_url or url", settings.Response.Type)
+	}
+
+	if poll := settings.Poll; poll != nil {
+		if poll.TaskIDPath == "" || poll.StatusPath == "" || len(poll.SuccessValues) == 0 {
+			return nil, fmt.Errorf("POLL requires TASK_ID_PATH, STATUS_PATH and SUCCESS_VALUES")
+		}
+		if p.pollRequest, err = parseRequestTemplate("POLL.REQUEST", poll.Request); err != nil {
+			return nil, err
+		}
+		p.poll = poll
+		p.pollInterval = defaultTemplatePollInterval
+		if poll.IntervalSeconds > 0 {
+			p.pollInterval = time.Duration(poll.IntervalSeconds) * time.Second
+		}
+	}
+	return p, nil
+}
+
+// parseRequestTemplate parses the URL, header and body templates of t.
+func parseRequestTemplate(section string, t config.HTTPRequestTemplate) (*requestTemplate, error) {
+	if t.URL == "" {
+		return nil, fmt.Errorf("%s.URL is required", section)
+	}
+	parse := func(name, text string) (*template.Template, error) {
+		tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s.%s template: %w", section, name, err)
+		}
+		return tmpl, nil
+	}
+
+	rt := &requestTemplate{method: strings.ToUpper(t.Method), headers: make(map[string]*template.Template, len(t.Headers))}
+	var err error
+	if rt.url, err = parse("URL", t.URL); err != nil {
+		return nil, err
+	}
+	for name, value := range t.Headers {
+		if rt.headers[name], err = parse("HEADERS."+name, value); err != nil {
+			return nil, err
+		}
+	}
+	if t.Body != "" {
+		if rt.body, err = parse("BODY", t.Body); err != nil {
+			return nil, err
+		}
+	}
+	if rt.method == "" {
+		rt.method = "GET"
+		if rt.body != nil {
+			rt.method = "POST"
+		}
+	}
+	return rt, nil
+}
+
+// build renders the template into an HTTP request.
+func (rt *requestTemplate) build(ctx context.Context, data httpTemplateData) (*http.Request, string, error) {
+	render := func(t *template.Template) (string, error) {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("failed to render %s template: %w", t.Name(), err)
+		}
+		return buf.String(), nil
+	}
+
+	url, err := render(rt.url)
+	if err != nil {
+		return nil, "", err
+	}
+	var body string
+	if rt.body != nil {
+		if body, err = render(rt.body); err != nil {
+			return nil, "", err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, rt.method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if rt.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, t := range rt.headers {
+		value, err := render(t)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set(name, value)
+	}
+	return req, body, nil
+}
+
+// GetName returns the configured name of the provider.
+func (p *HTTPTemplateProvider) GetName() string {
+	return p.Name
+}
+
+// GetModels returns the configured models and their capabilities.
+func (p *HTTPTemplateProvider) GetModels() []ModelCapabilities {
+	return p.Models
+}
+
+// Generate sends the templated request and extracts the images from the
+// response, polling for the result first if the provider is asynchronous.
+func (p *HTTPTemplateProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
+	data := httpTemplateData{GenerationInput: input}
+	if len(input.ImageBytes) > 0 {
+		data.ImageBase64 = base64.StdEncoding.EncodeToString(input.ImageBytes)
+	}
+	if len(input.MaskBytes) > 0 {
+		data.MaskBase64 = base64.StdEncoding.EncodeToString(input.MaskBytes)
+	}
+
+	req, body, err := p.request.build(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	log.Printf("Calling provider '%s' with model '%s'", p.GetName(), input.Model)
+	if data.ImageBase64 == "" {
+		// Bodies with image data are far too large to be useful in the log.
+		log.Printf("Request: %s %s\n%s", req.Method, req.URL, body)
+	}
+
+	respBody, err := p.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.response.Type == responseRaw {
+		return &GenerationOutput{Images: []GeneratedImage{{Bytes: respBody}}}, nil
+	}
+
+	result, err := decodeJSONNumbers(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to decode response: %w, body: %s", p.Name, err, string(respBody))
+	}
+	if p.poll != nil {
+		if result, err = p.pollResult(ctx, data, result); err != nil {
+			return nil, err
+		}
+	}
+	return p.extractImages(ctx, result)
+}
+
+// send executes req and returns the response body, failing on non-2xx status.
+func (p *HTTPTemplateProvider) send(req *http.Request) ([]byte, error) {
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to call external API: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read response body: %w", p.Name, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, statusError(resp.StatusCode, "%s: API returned non-2xx status: %d, body: %s", p.Name, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// pollResult reads the task ID from the submit response and polls the status
+// request until the task succeeds, fails or runs out of attempts. It returns
+// the final status response.
+func (p *HTTPTemplateProvider) pollResult(ctx context.Context, data httpTemplateData, submitted interface{}) (interface{}, error) {
+	taskID, err := lookupJSONPath(submitted, p.poll.TaskIDPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: did not receive a task ID: %w", p.Name, err)
+	}
+	data.TaskID = fmt.Sprint(taskID)
+	log.Printf("%s: task submitted successfully, task_id: %s", p.Name, data.TaskID)
+
+	maxAttempts := p.poll.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTemplatePollMaxAttempts
+	}
+	for i := 0; i < maxAttempts; i++ {
+		if err := sleepContext(ctx, p.pollInterval); err != nil {
+			return nil, fmt.Errorf("%s: stopped polling task %s: %w", p.Name, data.TaskID, err)
+		}
+
+		req, _, err := p.pollRequest.build(ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		body, err := p.send(req)
+		if err != nil {
+			// The task might still be processing or the endpoint temporarily unavailable.
+			log.Printf("%s: polling failed: %v", p.Name, err)
+			continue
+		}
+
+		result, err := decodeJSONNumbers(body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to decode task response: %w, body: %s", p.Name, err, string(body))
+		}
+		status, err := lookupJSONPath(result, p.poll.StatusPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: task response has no status: %w, body: %s", p.Name, err, string(body))
+		}
+
+		switch s := fmt.Sprint(status); {
+		case containsString(p.poll.SuccessValues, s):
+			return result, nil
+		case containsString(p.poll.FailureValues, s):
+			errMsg := fmt.Sprintf("%s: task %s ended with status '%s'", p.Name, data.TaskID, s)
+			if p.poll.ErrorPath != "" {
+				if reason, err := lookupJSONPath(result, p.poll.ErrorPath); err == nil {
+					errMsg = fmt.Sprintf("%s. Reason: %v", errMsg, reason)
+				}
+			}
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		// Otherwise, continue polling
+	}
+	return nil, fmt.Errorf("%s: polling timed out after %d attempts", p.Name, maxAttempts)
+}
+
+// extractImages applies the response rule to a decoded JSON response.
+func (p *HTTPTemplateProvider) extractImages(ctx context.Context, result interface{}) (*GenerationOutput, error) {
+	value, err := lookupJSONPath(result, p.response.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no image in response: %w", p.Name, err)
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	output := &GenerationOutput{}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("%s: expected a string at '%s', got %T", p.Name, p.response.Path, v)
+		}
+		var imageData []byte
+		switch p.response.Type {
+		case responseBase64:
+			imageData, err = base64.StdEncoding.DecodeString(s)
+		case responseDataURL:
+			_, encoded, found := strings.Cut(s, ",")
+			if !found {
+				return nil, fmt.Errorf("%s: invalid data URL in response", p.Name)
+			}
+			imageData, err = base64.StdEncoding.DecodeString(encoded)
+		case responseURL:
+			imageData, _, err = DownloadFile(ctx, s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read image from response: %w", p.Name, err)
+		}
+		output.Images = append(output.Images, GeneratedImage{Bytes: imageData})
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("%s: no images returned in response", p.Name)
+	}
+	return output, nil
+}
+
+// decodeJSONNumbers decodes a JSON response, keeping numbers as json.Number
+// so that task IDs and statuses are templated and matched exactly as the
+// upstream sent them; as float64, 12345678 would print as "1.2345678e+07".
+func decodeJSONNumbers(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// lookupJSONPath follows a dotted path such as "data.0.url" through a decoded
+// JSON value. Numeric keys index arrays, and "*" maps the rest of the path
+// over every array element, e.g. "data.*.url". An empty path returns v.
+func lookupJSONPath(v interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return v, nil
+	}
+	key, rest, _ := strings.Cut(path, ".")
+	switch node := v.(type) {
+	case map[string]interface{}:
+		next, ok := node[key]
+		if !ok {
+			return nil, fmt.Errorf("key '%s' not found", key)
+		}
+		return lookupJSONPath(next, rest)
+	case []interface{}:
+		if key == "*" {
+			values := make([]interface{}, 0, len(node))
+			for _, elem := range node {
+				value, err := lookupJSONPath(elem, rest)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			return values, nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, fmt.Errorf("index '%s' out of range for array of length %d", key, len(node))
+		}
+		return lookupJSONPath(node[i], rest)
+	default:
+		return nil, fmt.Errorf("cannot look up '%s' in %T", key, v)
+	}
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
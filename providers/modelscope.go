@@ -2,38 +2,75 @@ package providers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"time"
+
+	"imageapi/config"
+	"imageapi/httpclient"
 )
 
 const (
-	modelScopeAPIURL   = "https://api-inference.modelscope.cn/v1/images/generations"
-	modelScopeTaskURL  = "https://api-inference.modelscope.cn/v1/tasks/"
-	maxPollingAttempts = 90 // 5 minutes timeout (60 attempts * 5 seconds)
-	pollingInterval    = 5 * time.Second
+	modelScopeAPIURL  = "https://api-inference.modelscope.cn/v1/images/generations"
+	modelScopeTaskURL = "https://api-inference.modelscope.cn/v1/tasks/"
+
+	// Polling defaults: the first status check after 2 seconds, each further
+	// wait 1.5 times longer up to 10 seconds, and at most 5 minutes overall.
+	// PROVIDERS.Modelscope in conf.json can override all three.
+	modelScopePollInterval    = 2 * time.Second
+	modelScopeMaxPollInterval = 10 * time.Second
+	modelScopeMaxPollDuration = 5 * time.Minute
 )
 
 // ModelScopeProvider implements the ImageProvider for ModelScope.
 type ModelScopeProvider struct {
-	APIKey string
-	Client *http.Client
+	APIKey  string
+	Client  *http.Client
+	APIURL  string // Task submission endpoint
+	TaskURL string // Prefix of the task status endpoint
+
+	pollInterval    time.Duration
+	maxPollInterval time.Duration
+	maxPollDuration time.Duration
 }
 
+// modelScopeGuidance is the guidance range of the Qwen image models.
+var modelScopeGuidance = map[string]ParamRange{"guidance": {Min: 1.5, Max: 20, Default: 3.5}}
+
 var modelScopeModels = []ModelCapabilities{
-	{Name: "Qwen/Qwen-Image", SupportedParams: []string{"seed"}, MaxWidth: 2048, MaxHeight: 2048},
-	{Name: "Qwen/Qwen-Image-Edit", SupportedParams: []string{"seed", "image"}, MaxWidth: 2048, MaxHeight: 2048},
+	{Name: "Qwen/Qwen-Image", SupportedParams: []string{"seed", "negative_prompt", "steps", "guidance", "loras"}, MaxWidth: 2048, MaxHeight: 2048, MinSteps: 1, MaxSteps: 100, DefaultSteps: 30, ParamRanges: modelScopeGuidance, MaxLoRAs: 6},
+	{Name: "Qwen/Qwen-Image-Edit", SupportedParams: []string{"seed", "negative_prompt", "steps", "guidance"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportURL, MaxWidth: 2048, MaxHeight: 2048, MinSteps: 1, MaxSteps: 100, DefaultSteps: 30, ParamRanges: modelScopeGuidance},
 }
 
-// NewModelScopeProvider creates a new ModelScope client.
+// NewModelScopeProvider creates a new ModelScope client. The polling
+// intervals come from PROVIDERS.Modelscope in the configuration.
 func NewModelScopeProvider(apiKey string) *ModelScopeProvider {
-	return &ModelScopeProvider{
-		APIKey: apiKey,
-		Client: &http.Client{},
+	p := &ModelScopeProvider{
+		APIKey:          apiKey,
+		Client:          httpclient.New("Modelscope"),
+		APIURL:          modelScopeAPIURL,
+		TaskURL:         modelScopeTaskURL,
+		pollInterval:    modelScopePollInterval,
+		maxPollInterval: modelScopeMaxPollInterval,
+		maxPollDuration: modelScopeMaxPollDuration,
+	}
+	if ps, ok := config.AppConfig.Providers["Modelscope"]; ok {
+		if ps.PollIntervalSeconds > 0 {
+			p.pollInterval = time.Duration(ps.PollIntervalSeconds) * time.Second
+		}
+		if ps.MaxPollIntervalSeconds > 0 {
+			p.maxPollInterval = time.Duration(ps.MaxPollIntervalSeconds) * time.Second
+		}
+		if ps.MaxPollSeconds > 0 {
+			p.maxPollDuration = time.Duration(ps.MaxPollSeconds) * time.Second
+		}
 	}
+	p.maxPollInterval = max(p.maxPollInterval, p.pollInterval)
+	return p
 }
 
 // GetName returns the name of the provider.
@@ -41,21 +78,22 @@ func (p *ModelScopeProvider) GetName() string {
 	return "Modelscope"
 }
 
-// RequiresImageURL returns true as ModelScope requires an image URL.
-func (p *ModelScopeProvider) RequiresImageURL() bool {
-	return true
-}
-
 // GetModels returns the list of models and their capabilities for ModelScope.
 func (p *ModelScopeProvider) GetModels() []ModelCapabilities {
 	return modelScopeModels
 }
 
 type modelScopeAPIPayload struct {
-	Model    string `json:"model"`
-	Prompt   string `json:"prompt"`
-	ImageURL string `json:"image_url,omitempty"`
-	Size     string `json:"size"`
+	Model          string  `json:"model"`
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	ImageURL       string  `json:"image_url,omitempty"`
+	Size           string  `json:"size"`
+	Seed           int64   `json:"seed,omitempty"`
+	Steps          int     `json:"steps,omitempty"`
+	Guidance       float64 `json:"guidance,omitempty"`
+	// LoRAs maps adapter repositories to their weights.
+	LoRAs map[string]float64 `json:"loras,omitempty"`
 }
 
 type modelScopeAsyncResponse struct {
@@ -73,14 +111,25 @@ type modelScopeTaskResponse struct {
 	Errors       modelScopeErrorDetail `json:"errors,omitempty"`
 }
 
-// Generate sends a request to the ModelScope API and polls for the result.
-func (p *ModelScopeProvider) Generate(input GenerationInput) (*GenerationOutput, error) {
-	size := fmt.Sprintf("%dx%d", input.Width, input.Height)
+// Generate submits a task to the ModelScope API, polls it until it finishes
+// and downloads the images. A task we stop waiting for, because the client
+// went away or polling timed out, is canceled upstream.
+func (p *ModelScopeProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
 	payload := modelScopeAPIPayload{
-		Model:    input.Model,
-		Prompt:   input.Prompt,
-		ImageURL: input.ImageURL, // Will be empty for text-to-image
-		Size:     size,
+		Model:          input.Model,
+		Prompt:         input.Prompt,
+		NegativePrompt: input.NegativePrompt,
+		ImageURL:       input.ImageURL, // Will be empty for text-to-image
+		Size:           fmt.Sprintf("%dx%d", input.Width, input.Height),
+		Seed:           input.Seed,
+		Steps:          input.Steps,
+		Guidance:       input.Guidance,
+	}
+	if len(input.LoRAs) > 0 {
+		payload.LoRAs = make(map[string]float64, len(input.LoRAs))
+		for _, lora := range input.LoRAs {
+			payload.LoRAs[lora.Name] = lora.Weight
+		}
 	}
 
 	logPayloadBytes, err := json.MarshalIndent(payload, "", "  ")
@@ -97,7 +146,7 @@ func (p *ModelScopeProvider) Generate(input GenerationInput) (*GenerationOutput,
 	}
 
 	// 1. Initiate the generation task
-	req, err := http.NewRequest("POST", modelScopeAPIURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.APIURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return nil, fmt.Errorf("Modelscope: failed to create request: %w", err)
 	}
@@ -113,7 +162,7 @@ func (p *ModelScopeProvider) Generate(input GenerationInput) (*GenerationOutput,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Modelscope: generation API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp.StatusCode, "Modelscope: generation API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	var asyncResp modelScopeAsyncResponse
@@ -127,65 +176,129 @@ func (p *ModelScopeProvider) Generate(input GenerationInput) (*GenerationOutput,
 
 	log.Printf("Modelscope: task submitted successfully, task_id: %s", asyncResp.TaskID)
 
-	// 2. Poll for the result
-	taskURL := modelScopeTaskURL + asyncResp.TaskID
-	for i := 0; i < maxPollingAttempts; i++ {
-		time.Sleep(pollingInterval)
+	// 2. Poll for the result, canceling the task if we give up
+	imageURLs, err := p.waitForTask(ctx, asyncResp.TaskID)
+	if err != nil {
+		return nil, err
+	}
 
-		pollReq, err := http.NewRequest("GET", taskURL, nil)
+	// 3. Download the images
+	output := &GenerationOutput{}
+	for _, imageURL := range imageURLs {
+		imageData, _, err := DownloadFile(ctx, imageURL)
 		if err != nil {
-			return nil, fmt.Errorf("Modelscope: failed to create polling request: %w", err)
+			return nil, fmt.Errorf("Modelscope: failed to download generated image: %w", err)
 		}
-		pollReq.Header.Set("Authorization", "Bearer "+p.APIKey)
-		pollReq.Header.Set("X-ModelScope-Task-Type", "image_generation")
-
-		pollResp, err := p.Client.Do(pollReq)
-		if err != nil {
-			return nil, fmt.Errorf("Modelscope: failed to execute polling request: %w", err)
+		generated := GeneratedImage{Bytes: imageData}
+		if len(imageURLs) == 1 { // The seed only identifies a lone image
+			generated.Seed = input.Seed
 		}
-		defer pollResp.Body.Close()
+		output.Images = append(output.Images, generated)
+	}
+	return output, nil
+}
 
-		if pollResp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(pollResp.Body)
-			// Log the error but continue polling, as the task might still be processing or temporarily unavailable
-			log.Printf("Modelscope: polling returned non-200 status: %d, body: %s", pollResp.StatusCode, string(body))
-			continue
+// waitForTask polls a task until it succeeds and returns its image URLs. The
+// wait between polls grows from pollInterval to maxPollInterval. If ctx ends
+// or maxPollDuration passes first, the task is canceled.
+func (p *ModelScopeProvider) waitForTask(ctx context.Context, taskID string) ([]string, error) {
+	deadline := time.Now().Add(p.maxPollDuration)
+	interval := p.pollInterval
+	for attempt := 1; ; attempt++ {
+		if time.Now().Add(interval).After(deadline) {
+			p.cancelTask(taskID)
+			return nil, fmt.Errorf("Modelscope: polling task %s timed out after %v (%d attempts)", taskID, p.maxPollDuration, attempt-1)
 		}
-
-		bodyBytes, err := io.ReadAll(pollResp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("Modelscope: failed to read polling response body: %w", err)
+		if err := sleepContext(ctx, interval); err != nil {
+			p.cancelTask(taskID)
+			return nil, fmt.Errorf("Modelscope: stopped polling task %s: %w", taskID, err)
 		}
+		interval = min(interval*3/2, p.maxPollInterval)
 
-		var taskResp modelScopeTaskResponse
-		if err := json.Unmarshal(bodyBytes, &taskResp); err != nil {
-			// Return the raw body if JSON decoding fails
-			return nil, fmt.Errorf("Modelscope: failed to decode task response: %w, body: %s", err, string(bodyBytes))
+		taskResp, body, err := p.pollTask(ctx, taskID)
+		if err != nil {
+			if ctx.Err() != nil {
+				p.cancelTask(taskID)
+				return nil, fmt.Errorf("Modelscope: stopped polling task %s: %w", taskID, ctx.Err())
+			}
+			// The task might still be processing; the status endpoint can fail transiently.
+			log.Printf("Modelscope: polling task %s failed on attempt %d: %v", taskID, attempt, err)
+			continue
 		}
 
 		switch taskResp.TaskStatus {
 		case "SUCCEED":
-			if len(taskResp.OutputImages) > 0 {
-				imageURL := taskResp.OutputImages[0]
-				imageData, _, err := DownloadFile(imageURL)
-				if err != nil {
-					return nil, fmt.Errorf("Modelscope: failed to download generated image: %w", err)
-				}
-				return &GenerationOutput{
-					ImageBytes: imageData,
-				}, nil
+			if len(taskResp.OutputImages) == 0 {
+				return nil, fmt.Errorf("Modelscope: task succeeded but no image URL was returned")
 			}
-			return nil, fmt.Errorf("Modelscope: task succeeded but no image URL was returned")
+			return taskResp.OutputImages, nil
 		case "FAILED", "CANCELED":
 			errMsg := "Modelscope: task failed or was canceled"
 			if taskResp.Errors.Message != "" {
 				errMsg = fmt.Sprintf("%s. Reason: %s", errMsg, taskResp.Errors.Message)
 			}
 			// Also include the full body for debugging
-			return nil, fmt.Errorf("%s. Full Response: %s", errMsg, string(bodyBytes))
+			return nil, fmt.Errorf("%s. Full Response: %s", errMsg, string(body))
 		}
 		// Otherwise, continue polling
 	}
+}
 
-	return nil, fmt.Errorf("Modelscope: polling timed out after %d attempts", maxPollingAttempts)
+// pollTask fetches the status of a task. It also returns the raw body, which
+// failed tasks include in their error.
+func (p *ModelScopeProvider) pollTask(ctx context.Context, taskID string) (*modelScopeTaskResponse, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.TaskURL+taskID, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create polling request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("X-ModelScope-Task-Type", "image_generation")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to execute polling request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read polling response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, statusError(resp.StatusCode, "polling returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var taskResp modelScopeTaskResponse
+	if err := json.Unmarshal(body, &taskResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode task response: %w, body: %s", err, string(body))
+	}
+	return &taskResp, body, nil
+}
+
+// cancelTask asks ModelScope to stop a task nobody waits for anymore.
+func (p *ModelScopeProvider) cancelTask(taskID string) {
+	// Use a fresh context: the request context may already be done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TaskURL+taskID+"/cancel", nil)
+	if err != nil {
+		log.Printf("Warning: failed to cancel Modelscope task %s: %v", taskID, err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("X-ModelScope-Task-Type", "image_generation")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		log.Printf("Warning: failed to cancel Modelscope task %s: %v", taskID, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		log.Printf("Warning: failed to cancel Modelscope task %s: status %d, body: %s", taskID, resp.StatusCode, string(body))
+		return
+	}
+	log.Printf("Modelscope: canceled task %s", taskID)
 }
@@ -0,0 +1,296 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Input images are usually JPEG
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"imageapi/httpclient"
+
+	"github.com/nfnt/resize"
+)
+
+// OpenAICompatibleProvider implements the ImageProvider for any backend that
+// speaks the OpenAI /v1/images/generations and /v1/images/edits API, such as
+// self-hosted gateways, SiliconFlow or one-api relays. Instances are created
+// from the OPENAI_COMPATIBLE section of conf.json.
+type OpenAICompatibleProvider struct {
+	Name    string
+	BaseURL string // Base URL including the version, e.g. "https://api.siliconflow.cn/v1"
+	APIKey  string
+	// ResponseFormat is sent as response_format ("b64_json" or "url").
+	// Leave empty to let the upstream choose; both are handled.
+	ResponseFormat string
+	Models         []ModelCapabilities
+	Client         *http.Client
+}
+
+// NewOpenAICompatibleProvider creates a new OpenAI-compatible client.
+func NewOpenAICompatibleProvider(name, baseURL, apiKey, responseFormat string, models []ModelCapabilities) *OpenAICompatibleProvider {
+	return &OpenAICompatibleProvider{
+		Name:           name,
+		BaseURL:        strings.TrimRight(baseURL, "/"),
+		APIKey:         apiKey,
+		ResponseFormat: responseFormat,
+		Models:         models,
+		Client:         httpclient.New(name),
+	}
+}
+
+// GetName returns the configured name of the provider.
+func (p *OpenAICompatibleProvider) GetName() string {
+	return p.Name
+}
+
+// GetModels returns the configured models and their capabilities.
+func (p *OpenAICompatibleProvider) GetModels() []ModelCapabilities {
+	return p.Models
+}
+
+// openAIGenerationPayload is the JSON body of /images/generations. The
+// fields after Size are extensions understood by many compatible backends.
+type openAIGenerationPayload struct {
+	Model             string  `json:"model"`
+	Prompt            string  `json:"prompt"`
+	N                 int     `json:"n,omitempty"`
+	Size              string  `json:"size,omitempty"`
+	ResponseFormat    string  `json:"response_format,omitempty"`
+	NegativePrompt    string  `json:"negative_prompt,omitempty"`
+	Seed              int64   `json:"seed,omitempty"`
+	NumInferenceSteps int     `json:"num_inference_steps,omitempty"`
+	GuidanceScale     float64 `json:"guidance_scale,omitempty"`
+}
+
+// openAIImageResponse matches the response of both image endpoints.
+type openAIImageResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+		URL     string `json:"url"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Generate calls /images/edits when an input image is provided and
+// /images/generations otherwise.
+func (p *OpenAICompatibleProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
+	modelCaps, found := FindModel(p, input.Model)
+	if !found {
+		return nil, fmt.Errorf("%s: model '%s' not found or not supported", p.Name, input.Model)
+	}
+
+	var req *http.Request
+	var err error
+	if len(input.ImageBytes) > 0 {
+		req, err = p.newEditRequest(ctx, input)
+	} else {
+		req, err = p.newGenerationRequest(ctx, input, modelCaps)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to call external API: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read response body: %w", p.Name, err)
+	}
+
+	var apiResp openAIImageResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, statusError(resp.StatusCode, "%s: API returned non-200 status: %d, body: %s", p.Name, resp.StatusCode, string(respBody))
+		}
+		return nil, fmt.Errorf("%s: failed to decode response: %w", p.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK || apiResp.Error != nil {
+		if apiResp.Error != nil {
+			return nil, statusError(resp.StatusCode, "%s: API error (status %d): %s", p.Name, resp.StatusCode, apiResp.Error.Message)
+		}
+		return nil, statusError(resp.StatusCode, "%s: API returned non-200 status: %d, body: %s", p.Name, resp.StatusCode, string(respBody))
+	}
+
+	if len(apiResp.Data) == 0 {
+		return nil, fmt.Errorf("%s: no images returned in response", p.Name)
+	}
+
+	output := &GenerationOutput{}
+	for _, item := range apiResp.Data {
+		var imageData []byte
+		switch {
+		case item.B64JSON != "":
+			imageData, err = base64.StdEncoding.DecodeString(item.B64JSON)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to decode base64 image data: %w", p.Name, err)
+			}
+		case item.URL != "":
+			imageData, _, err = DownloadFile(ctx, item.URL)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to download generated image: %w", p.Name, err)
+			}
+		default:
+			continue
+		}
+		output.Images = append(output.Images, GeneratedImage{Bytes: imageData})
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("%s: response contained neither b64_json nor url", p.Name)
+	}
+	return output, nil
+}
+
+func (p *OpenAICompatibleProvider) newGenerationRequest(ctx context.Context, input GenerationInput, modelCaps ModelCapabilities) (*http.Request, error) {
+	payload := openAIGenerationPayload{
+		Model:          input.Model,
+		Prompt:         input.Prompt,
+		N:              input.BatchSize,
+		Size:           fmt.Sprintf("%dx%d", input.Width, input.Height),
+		ResponseFormat: p.ResponseFormat,
+	}
+	// Only send extension parameters to models that declare them.
+	if modelCaps.Supports("negative_prompt") {
+		payload.NegativePrompt = input.NegativePrompt
+	}
+	if modelCaps.Supports("seed") {
+		payload.Seed = input.Seed
+	}
+	if modelCaps.Supports("steps") {
+		payload.NumInferenceSteps = input.Steps
+	}
+	if modelCaps.Supports("guidance") {
+		payload.GuidanceScale = input.Guidance
+	}
+
+	logPayloadBytes, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling log payload: %v", err)
+	} else {
+		log.Printf("Calling provider '%s' with model '%s'", p.GetName(), input.Model)
+		log.Printf("Request payload: \n%s", string(logPayloadBytes))
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to marshal payload: %w", p.Name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/images/generations", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to create request: %w", p.Name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (p *OpenAICompatibleProvider) newEditRequest(ctx context.Context, input GenerationInput) (*http.Request, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	fields := map[string]string{
+		"model":  input.Model,
+		"prompt": input.Prompt,
+		"size":   fmt.Sprintf("%dx%d", input.Width, input.Height),
+	}
+	if input.BatchSize > 0 {
+		fields["n"] = strconv.Itoa(input.BatchSize)
+	}
+	if p.ResponseFormat != "" {
+		fields["response_format"] = p.ResponseFormat
+	}
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return nil, fmt.Errorf("%s: failed to write form field %s: %w", p.Name, name, err)
+		}
+	}
+
+	var mask []byte
+	if len(input.MaskBytes) > 0 {
+		var err error
+		if mask, err = alphaMask(input.MaskBytes, input.ImageBytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+	}
+	files := []struct {
+		field, filename string
+		data            []byte
+	}{
+		{"image", imageFilename("image", input.ImageBytes), input.ImageBytes},
+		{"mask", "mask.png", mask},
+	}
+	for _, f := range files {
+		if len(f.data) == 0 {
+			continue
+		}
+		part, err := writer.CreateFormFile(f.field, f.filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to create form file: %w", p.Name, err)
+		}
+		if _, err := part.Write(f.data); err != nil {
+			return nil, fmt.Errorf("%s: failed to copy %s bytes to form: %w", p.Name, f.field, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("%s: failed to finalize form: %w", p.Name, err)
+	}
+
+	log.Printf("Calling provider '%s' edits endpoint with model '%s' (mask: %t)", p.GetName(), input.Model, len(input.MaskBytes) > 0)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/images/edits", body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to create request: %w", p.Name, err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+// alphaMask converts a grayscale mask, where white marks the area to repaint,
+// to the mask the OpenAI edits endpoint expects: a PNG with an alpha channel
+// that is transparent where the image should be edited, the same size as
+// the image.
+func alphaMask(maskBytes, imageBytes []byte) ([]byte, error) {
+	mask, _, err := image.Decode(bytes.NewReader(maskBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mask: %w", err)
+	}
+	size, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input image size: %w", err)
+	}
+	if b := mask.Bounds(); b.Dx() != size.Width || b.Dy() != size.Height {
+		mask = resize.Resize(uint(size.Width), uint(size.Height), mask, resize.NearestNeighbor)
+	}
+
+	bounds := mask.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := color.GrayModel.Convert(mask.At(x, y)).(color.Gray)
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{A: 255 - gray.Y})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, fmt.Errorf("failed to encode mask: %w", err)
+	}
+	return buf.Bytes(), nil
+}
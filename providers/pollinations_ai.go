@@ -1,15 +1,21 @@
 package providers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"time"
+
+	"imageapi/httpclient"
 )
 
-const pollinationsAIAPIURL = "https://image.pollinations.ai/prompt/"
+const (
+	pollinationsAIAPIURL    = "https://image.pollinations.ai/prompt/"
+	pollinationsAIModelsURL = "https://image.pollinations.ai/models"
+)
 
 // PollinationsAIProvider implements the ImageProvider for Pollinations.ai.
 type PollinationsAIProvider struct {
@@ -18,15 +24,15 @@ type PollinationsAIProvider struct {
 }
 
 var pollinationsAIModels = []ModelCapabilities{
-	{Name: "flux", SupportedParams: []string{"seed"}, MaxWidth: 1024, MaxHeight: 1024},
-	{Name: "kontext", SupportedParams: []string{"seed", "image"}, MaxWidth: 1024, MaxHeight: 1024},
+	{Name: "flux", SupportedParams: []string{"seed"}, MaxWidth: 1024, MaxHeight: 1024, EnglishPrompt: true},
+	{Name: "kontext", SupportedParams: []string{"seed"}, Tasks: []Task{TaskEdit}, ImageInput: ImageRequired, MaxInputImages: 1, InputTransport: TransportURL, MaxWidth: 1024, MaxHeight: 1024, EnglishPrompt: true},
 }
 
 // NewPollinationsAIProvider creates a new Pollinations.ai client.
 func NewPollinationsAIProvider(apiKey string) *PollinationsAIProvider {
 	return &PollinationsAIProvider{
 		APIKey: apiKey,
-		Client: &http.Client{},
+		Client: httpclient.New("Pollinations_ai"),
 	}
 }
 
@@ -35,31 +41,68 @@ func (p *PollinationsAIProvider) GetName() string {
 	return "Pollinations_ai"
 }
 
-// RequiresImageURL returns true as Pollinations.ai requires an image URL.
-func (p *PollinationsAIProvider) RequiresImageURL() bool {
-	return true
-}
-
 // GetModels returns the list of models and their capabilities for Pollinations.ai.
 func (p *PollinationsAIProvider) GetModels() []ModelCapabilities {
 	return pollinationsAIModels
 }
 
+// DiscoverModels lists the image models Pollinations.ai currently serves.
+// The endpoint returns plain model names; newer versions return objects with
+// a "name" field, so both are accepted.
+func (p *PollinationsAIProvider) DiscoverModels(ctx context.Context) ([]ModelCapabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pollinationsAIModelsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Pollinations_ai: failed to create models request: %w", err)
+	}
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Pollinations_ai: failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError(resp.StatusCode, "Pollinations_ai: models endpoint returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var entries []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Pollinations_ai: failed to decode models response: %w", err)
+	}
+
+	var models []ModelCapabilities
+	for _, entry := range entries {
+		var name string
+		if err := json.Unmarshal(entry, &name); err != nil {
+			var obj struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(entry, &obj); err != nil {
+				continue
+			}
+			name = obj.Name
+		}
+		if name != "" {
+			models = append(models, ModelCapabilities{Name: name, SupportedParams: []string{"seed"}, MaxWidth: 1024, MaxHeight: 1024, EnglishPrompt: true})
+		}
+	}
+	return models, nil
+}
+
 // Generate sends a request to the Pollinations.ai API.
-func (p *PollinationsAIProvider) Generate(input GenerationInput) (*GenerationOutput, error) {
+func (p *PollinationsAIProvider) Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error) {
 	// The prompt is always part of the path, and needs to be path-escaped.
 	encodedPrompt := url.PathEscape(input.Prompt)
 	fullURL := pollinationsAIAPIURL + encodedPrompt
 
 	params := url.Values{}
 
-	// This provider requires an image URL. The main handler is now responsible
-	// for uploading the image and providing the URL in input.ImageURL.
-	if input.ImageURL == "" && input.Model == "kontext" { // Kontext model requires an image
-		return nil, fmt.Errorf("Pollinations_ai: model '%s' requires an image URL", input.Model)
-	}
-
-	// For img2img, add the final image URL as a query parameter.
+	// For img2img, add the image URL as a query parameter. The central request
+	// path uploads the image and enforces the model's image requirement.
 	if input.ImageURL != "" {
 		params.Add("image", input.ImageURL)
 	}
@@ -81,7 +124,7 @@ func (p *PollinationsAIProvider) Generate(input GenerationInput) (*GenerationOut
 	log.Printf("Calling provider '%s' with model '%s'", p.GetName(), input.Model)
 	log.Printf("Request URL: %s", fullURL)
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Pollinations_ai: failed to create request: %w", err)
 	}
@@ -90,40 +133,17 @@ func (p *PollinationsAIProvider) Generate(input GenerationInput) (*GenerationOut
 		req.Header.Set("Authorization", "Bearer "+p.APIKey)
 	}
 
-	var resp *http.Response
-	const maxRetries = 4 // 1 initial attempt + 3 retries
-	const retryInterval = 3 * time.Second
-
-	for i := 0; i < maxRetries; i++ {
-		resp, err = p.Client.Do(req)
-		if err != nil {
-			log.Printf("Error from provider '%s' on attempt %d/%d: %v", p.GetName(), i+1, maxRetries, err)
-			if i < maxRetries-1 {
-				log.Printf("Retrying in %v...", retryInterval)
-				time.Sleep(retryInterval)
-				continue
-			}
-			return nil, fmt.Errorf("Pollinations_ai: failed to call external API after %d attempts: %w", maxRetries, err)
-		}
-
-		if resp.StatusCode == http.StatusOK {
-			break // Success
-		}
+	// Transient failures are retried by the client according to the retry policy.
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Pollinations_ai: failed to call external API: %w", err)
+	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close() // Must close body to reuse connection.
-		err = fmt.Errorf("API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
-		log.Printf("Error from provider '%s' on attempt %d/%d: %v", p.GetName(), i+1, maxRetries, err)
-
-		if i < maxRetries-1 {
-			log.Printf("Retrying in %v...", retryInterval)
-			time.Sleep(retryInterval)
-			continue
-		}
-
-		return nil, fmt.Errorf("Pollinations_ai: giving up after %d attempts: %w", maxRetries, err)
+		return nil, statusError(resp.StatusCode, "Pollinations_ai: API returned non-200 status: %d, body: %s", resp.StatusCode, string(body))
 	}
-	defer resp.Body.Close()
 
 	// The response is the raw image data
 	imageData, err := io.ReadAll(resp.Body)
@@ -138,7 +158,6 @@ func (p *PollinationsAIProvider) Generate(input GenerationInput) (*GenerationOut
 	}
 
 	return &GenerationOutput{
-		ImageBytes: imageData,
-		Format:     format,
+		Images: []GeneratedImage{{Bytes: imageData, Format: format}},
 	}, nil
 }
@@ -1,14 +1,141 @@
 package providers
 
+import "context"
+
+// ParamRange describes the accepted range of a numeric parameter such as
+// "guidance" or "strength".
+type ParamRange struct {
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Default float64 `json:"default"`
+}
+
+// Task is a kind of job a model can perform.
+type Task string
+
+const (
+	TaskTextToImage Task = "text-to-image" // Generate an image from the prompt alone
+	TaskEdit        Task = "edit"          // Transform a whole input image
+	TaskInpaint     Task = "inpaint"       // Repaint the masked area of an input image
+	TaskUpscale     Task = "upscale"       // Enlarge an input image
+)
+
+// ImageRequirement states whether a model takes an input image.
+type ImageRequirement string
+
+const (
+	ImageNone     ImageRequirement = "none"
+	ImageOptional ImageRequirement = "optional"
+	ImageRequired ImageRequirement = "required"
+)
+
+// ImageTransport is how input images are handed to a model.
+type ImageTransport string
+
+const (
+	TransportBytes ImageTransport = "bytes" // GenerationInput.ImageBytes
+	TransportURL   ImageTransport = "url"   // GenerationInput.ImageURL, uploaded to the temporary image host
+)
+
 // ModelCapabilities defines the specific capabilities of an AI model.
 type ModelCapabilities struct {
 	Name            string   `json:"name"`
 	SupportedParams []string `json:"supported_params"`
-	MaxWidth        int      `json:"max_width"`
-	MaxHeight       int      `json:"max_height"`
-	MinSteps        int      `json:"min_steps,omitempty"`
-	MaxSteps        int      `json:"max_steps,omitempty"`
-	DefaultSteps    int      `json:"default_steps,omitempty"`
+	// Tasks lists what the model can do; ImageInput, MaxInputImages and
+	// InputTransport describe the input images it accepts. Zero values are
+	// filled in by Normalize.
+	Tasks          []Task           `json:"tasks"`
+	ImageInput     ImageRequirement `json:"image_input"`
+	MaxInputImages int              `json:"max_input_images"`
+	InputTransport ImageTransport   `json:"input_transport,omitempty"`
+	MaxWidth       int              `json:"max_width"`
+	MaxHeight      int              `json:"max_height"`
+	MinSteps       int              `json:"min_steps,omitempty"`
+	MaxSteps       int              `json:"max_steps,omitempty"`
+	DefaultSteps   int              `json:"default_steps,omitempty"`
+	// MaxBatch is the number of images the upstream can produce in a single
+	// call. Zero or one means no native batching.
+	MaxBatch int `json:"max_batch,omitempty"`
+	// SizeMultiple is the number width and height must be a multiple of,
+	// e.g. 8 for Stable Diffusion. Zero means any size.
+	SizeMultiple int `json:"size_multiple,omitempty"`
+	// ParamRanges holds the range of each numeric parameter listed in
+	// SupportedParams, keyed by parameter name.
+	ParamRanges map[string]ParamRange `json:"param_ranges,omitempty"`
+	// MaxLoRAs is the number of LoRA adapters a request may stack, for
+	// models that list "loras" in SupportedParams. Zero means no limit.
+	MaxLoRAs int `json:"max_loras,omitempty"`
+	// EnglishPrompt marks models that follow English prompts much better
+	// than other languages; their prompts are translated when prompt
+	// translation is enabled.
+	EnglishPrompt bool `json:"english_prompt,omitempty"`
+}
+
+// Supports reports whether param is listed in the model's SupportedParams.
+func (m ModelCapabilities) Supports(param string) bool {
+	for _, p := range m.SupportedParams {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTask reports whether task is listed in the model's Tasks.
+func (m ModelCapabilities) HasTask(task Task) bool {
+	for _, t := range m.Tasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsImage reports whether the model takes an input image at all.
+func (m ModelCapabilities) AcceptsImage() bool {
+	return m.ImageInput == ImageOptional || m.ImageInput == ImageRequired
+}
+
+// Normalize fills in the capability flags a model definition left empty.
+// Models that list "image" or "mask" in SupportedParams (the older way of
+// declaring image input, still used by conf.json) get an optional image and
+// the edit or inpaint task respectively.
+func (m ModelCapabilities) Normalize() ModelCapabilities {
+	if m.ImageInput == "" {
+		m.ImageInput = ImageNone
+		if m.Supports("image") || m.Supports("mask") {
+			m.ImageInput = ImageOptional
+		}
+	}
+	if len(m.Tasks) == 0 {
+		if m.ImageInput != ImageRequired {
+			m.Tasks = append(m.Tasks, TaskTextToImage)
+		}
+		if m.AcceptsImage() {
+			m.Tasks = append(m.Tasks, TaskEdit)
+		}
+		if m.Supports("mask") {
+			m.Tasks = append(m.Tasks, TaskInpaint)
+		}
+	}
+	if m.AcceptsImage() {
+		if m.MaxInputImages == 0 {
+			m.MaxInputImages = 1
+		}
+		if m.InputTransport == "" {
+			m.InputTransport = TransportBytes
+		}
+	} else {
+		m.MaxInputImages = 0
+		m.InputTransport = ""
+	}
+	return m
+}
+
+// ParamDefault returns the default value of a ranged parameter, or zero if
+// the model declares no range for it.
+func (m ModelCapabilities) ParamDefault(param string) float64 {
+	return m.ParamRanges[param].Default
 }
 
 // GenerationInput defines the standardized input for all AI providers.
@@ -16,29 +143,46 @@ type GenerationInput struct {
 	Prompt     string
 	ImageBytes []byte // User-provided image file bytes
 	ImageURL   string // User-provided image URL (for providers that need it)
+	MaskBytes  []byte // Optional mask for edits; white marks the area to repaint
 	Width      int
 	Height     int
 	Model      string // The specific model name, e.g., "stable-diffusion"
 	Seed       int64
 	Steps      int `json:"steps,omitempty"`
+	BatchSize  int // Number of images requested; never more than the model's MaxBatch
+
+	NegativePrompt string  // Things the image should not contain
+	Guidance       float64 // Classifier-free guidance scale; zero means the model default
+	Strength       float64 // Denoise strength for image-to-image; zero means the model default
+	LoRAs          []LoRA  // LoRA adapters to apply, for models that support "loras"
+}
+
+// LoRA is a LoRA adapter applied on top of the base model.
+type LoRA struct {
+	Name   string  `json:"name"`             // Adapter ID, e.g. a ModelScope repository
+	Weight float64 `json:"weight,omitempty"` // Scale of the adapter; zero means 1
+}
+
+// GeneratedImage is a single image produced by a provider.
+type GeneratedImage struct {
+	Bytes  []byte // The generated image bytes
+	Format string // The format of the image, e.g., "png", "jpeg"
+	Seed   int64  // A seed that reproduces exactly this image, if known; not a batch's base seed
 }
 
 // GenerationOutput defines the standardized output from all AI providers.
 type GenerationOutput struct {
-	ImageBytes []byte // The generated image bytes
-	ImageURL   string // URL of the generated image (if applicable)
-	Format     string // The format of the image, e.g., "png", "jpeg"
+	Images []GeneratedImage // Every image returned by the upstream, in order
 }
 
 // ImageProvider is the interface that all AI providers must implement.
 type ImageProvider interface {
-	// Generate an image based on the provided input.
-	Generate(input GenerationInput) (*GenerationOutput, error)
+	// Generate an image based on the provided input. Implementations must stop
+	// any upstream requests or polling as soon as ctx is done.
+	Generate(ctx context.Context, input GenerationInput) (*GenerationOutput, error)
 	// GetName returns the name of the provider (e.g., "dreamifly").
 	GetName() string
-	// GetModels returns a list of models supported by the provider and their capabilities.
+	// GetModels returns the static list of models supported by the provider
+	// and their capabilities. Use Models to include discovered models.
 	GetModels() []ModelCapabilities
-	// RequiresImageURL returns true if the provider needs an image URL
-	// instead of image bytes for image-to-image tasks.
-	RequiresImageURL() bool
 }
@@ -1,22 +1,31 @@
 package providers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"imageapi/httpclient"
 )
 
 // DownloadFile downloads a file from a URL and returns its content and content type.
-func DownloadFile(url string) ([]byte, string, error) {
-	resp, err := http.Get(url)
+// The download is aborted when ctx is done.
+func DownloadFile(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := httpclient.New("download").Do(req)
 	if err != nil {
 		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("bad status: %s", resp.Status)
+		return nil, "", statusError(resp.StatusCode, "bad status: %s", resp.Status)
 	}
 
 	contentType := resp.Header.Get("Content-Type")
@@ -27,6 +36,33 @@ func DownloadFile(url string) ([]byte, string, error) {
 	return data, contentType, nil
 }
 
+// StatusError is returned when an upstream answers with an unexpected HTTP
+// status, so callers can tell rejected requests from upstream failures.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// statusError returns a StatusError for code with a formatted message.
+func statusError(code int, format string, args ...any) error {
+	return &StatusError{StatusCode: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// FindModel returns the capabilities of the named model offered by provider,
+// including models found by discovery.
+func FindModel(provider ImageProvider, modelName string) (ModelCapabilities, bool) {
+	for _, m := range Models(provider) {
+		if m.Name == modelName {
+			return m.Normalize(), true
+		}
+	}
+	return ModelCapabilities{}, false
+}
+
 // ParseModelName splits a full model name string into its provider and model parts.
 // The expected format is "provider/model_name".
 func ParseModelName(fullModelName string) (string, string, error) {
@@ -36,3 +72,27 @@ func ParseModelName(fullModelName string) (string, string, error) {
 	}
 	return parts[0], parts[1], nil
 }
+
+// sleepContext pauses for d, returning early with ctx's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// imageFilename returns name with an extension matching the sniffed image format.
+func imageFilename(name string, data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return name + ".jpg"
+	case "image/webp":
+		return name + ".webp"
+	default:
+		return name + ".png"
+	}
+}
@@ -19,6 +19,8 @@ document.addEventListener('DOMContentLoaded', function () {
     const dynamicParams = document.querySelectorAll('.dynamic-param');
     const optimizeBtn = document.getElementById('optimize-btn');
     const promptTextarea = document.getElementById('prompt');
+    const optimizeStyleSelect = document.getElementById('optimize-style');
+    const styleSelect = document.getElementById('style');
     const inputSizeLimitGroup = document.getElementById('input-size-limit-group');
    
     let modelsData = []; // To store the data from /api/models
@@ -32,6 +34,11 @@ document.addEventListener('DOMContentLoaded', function () {
             modelsData.forEach(provider => {
                 const optgroup = document.createElement('optgroup');
                 optgroup.label = provider.provider;
+                // Mark providers whose upstream is currently failing
+                const statusLabels = { degraded: '不稳定', unavailable: '暂不可用' };
+                if (statusLabels[provider.status]) {
+                    optgroup.label += ` (${statusLabels[provider.status]})`;
+                }
                 provider.models.forEach(model => {
                     const option = document.createElement('option');
                     option.value = model.name;
@@ -59,13 +66,28 @@ document.addEventListener('DOMContentLoaded', function () {
         const modelInfo = JSON.parse(selectedOption.dataset.modelInfo);
         const supportedParams = modelInfo.supported_params;
       
-        // Toggle visibility of dynamic parameter controls
+        // Toggle visibility of dynamic parameter controls.
+        // Hidden controls are disabled so they are not submitted with the form.
         dynamicParams.forEach(paramEl => {
             const paramName = paramEl.dataset.param;
-            if (supportedParams.includes(paramName)) {
-                paramEl.classList.remove('hidden');
-            } else {
-                paramEl.classList.add('hidden');
+            // The mask inputs belong to models that can inpaint rather than to a parameter.
+            const supported = paramName === 'mask'
+                ? modelInfo.tasks.includes('inpaint')
+                : supportedParams.includes(paramName);
+            paramEl.classList.toggle('hidden', !supported);
+            paramEl.querySelectorAll('input, textarea').forEach(el => el.disabled = !supported);
+
+            // Apply the model's range for numeric parameters such as guidance and strength.
+            const range = modelInfo.param_ranges && modelInfo.param_ranges[paramName];
+            const rangeInput = paramEl.querySelector('input[type="range"]');
+            if (supported && range && rangeInput && paramName !== 'steps') {
+                rangeInput.min = range.min;
+                rangeInput.max = range.max;
+                rangeInput.value = range.default;
+                const valueEl = paramEl.querySelector('.range-value');
+                if (valueEl) {
+                    valueEl.textContent = range.default;
+                }
             }
         });
 
@@ -78,7 +100,7 @@ document.addEventListener('DOMContentLoaded', function () {
         }
       
         // Toggle visibility of image-related inputs
-        if (supportedParams.includes('image')) {
+        if (modelInfo.image_input !== 'none') {
         	imageUploadGroup.classList.remove('hidden');
         	imageUrlGroup.classList.remove('hidden');
         	inputSizeLimitGroup.classList.remove('hidden');
@@ -134,16 +156,122 @@ document.addEventListener('DOMContentLoaded', function () {
     clearImageBtn.addEventListener('click', clearImage);
 
     // --- 4. Form Submission ---
+    // Prompts with {a|b} alternations or __wildcards__, and any sweep, go to
+    // the batch endpoint, which runs one generation per combination.
+    const dynamicPromptSyntax = /\{[^{}]*\|[^{}]*\}|__[A-Za-z0-9][\w\-\/]*__/;
+    const sweepFields = ['sweep_seed', 'sweep_steps', 'sweep_model'];
+
+    function isBatch(formData) {
+        return dynamicPromptSyntax.test(formData.get('prompt') || '') ||
+            sweepFields.some(field => (formData.get(field) || '').trim() !== '');
+    }
+
+    // Reads the metadata of a generation returned as a bare image, which the
+    // server sends base64 encoded in a header.
+    function metadataFromHeaders(response) {
+        const encoded = response.headers.get('X-Generation-Metadata');
+        if (!encoded) {
+            return null;
+        }
+        const bytes = Uint8Array.from(atob(encoded), c => c.charCodeAt(0));
+        return JSON.parse(new TextDecoder().decode(bytes));
+    }
+
+    // Fills the form with the effective settings of a generation and submits
+    // it again.
+    function reuseSettings(metadata) {
+        // Changing the model resets the parameter controls, so it goes first.
+        modelSelect.value = metadata.model;
+        modelSelect.dispatchEvent(new Event('change'));
+        styleSelect.value = ''; // The style is already part of the prompt
+        sweepFields.forEach(field => document.getElementById(field).value = '');
+
+        promptTextarea.value = metadata.prompt;
+        document.getElementById('negative_prompt').value = metadata.negative_prompt || '';
+        document.getElementById('seed').value = metadata.seed || '';
+        document.getElementById('n').value = metadata.n;
+        document.getElementById('loras').value = (metadata.loras || []).map(lora => `${lora.name}:${lora.weight}`).join(',');
+        widthInput.value = metadata.width;
+        heightInput.value = metadata.height;
+        if (metadata.steps) {
+            stepsInput.value = metadata.steps;
+            stepsValue.textContent = stepsInput.value;
+        }
+        ['guidance', 'strength'].forEach(name => {
+            if (metadata[name]) {
+                const input = document.getElementById(name);
+                input.value = metadata[name];
+                input.parentElement.querySelector('.range-value').textContent = input.value;
+            }
+        });
+        form.requestSubmit();
+    }
+
+    // Describes a generation and offers to run it again with the same settings.
+    function generationInfo(metadata) {
+        const info = document.createElement('div');
+        info.className = 'generation-info';
+        const text = document.createElement('span');
+        const seconds = ms => (ms / 1000).toFixed(1);
+        text.textContent = `ID ${metadata.id} · ${metadata.model} · ${metadata.width}x${metadata.height}` +
+            ` · 上游 ${seconds(metadata.timings.provider_ms)}s / 总计 ${seconds(metadata.timings.total_ms)}s`;
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.textContent = '复用参数';
+        button.title = metadata.prompt;
+        button.addEventListener('click', () => reuseSettings(metadata));
+        info.append(text, button);
+        return info;
+    }
+
+    // Shows every job of a batch with the choices that produced it.
+    function renderBatch(batch) {
+        resultContainer.innerHTML = '';
+        const summary = document.createElement('p');
+        summary.className = 'translation-note';
+        summary.textContent = `共 ${batch.total} 组，成功 ${batch.succeeded} 组`;
+        resultContainer.appendChild(summary);
+
+        batch.results.forEach(item => {
+            const label = item.variables.map(v => `${v.name} = ${v.value}`).join('，') || item.prompt;
+            if (item.status !== 'success') {
+                const error = document.createElement('p');
+                error.className = 'error';
+                error.textContent = `${label}: ${item.error}`;
+                resultContainer.appendChild(error);
+                return;
+            }
+            item.images.forEach(img => {
+                const figure = document.createElement('figure');
+                const image = document.createElement('img');
+                image.src = img.url;
+                image.alt = item.prompt;
+                image.title = item.prompt;
+                const caption = document.createElement('figcaption');
+                caption.textContent = img.seed ? `${label}，种子 (Seed): ${img.seed}` : label;
+                figure.append(image, caption);
+                resultContainer.appendChild(figure);
+            });
+            if (item.metadata) {
+                resultContainer.appendChild(generationInfo(item.metadata));
+            }
+        });
+    }
+
     form.addEventListener('submit', function (e) {
         e.preventDefault();
 
         const formData = new FormData(form);
+        const batch = isBatch(formData);
+        if (!batch) {
+            sweepFields.forEach(field => formData.delete(field));
+        }
 
         submitBtn.disabled = true;
         loadingIndicator.classList.remove('hidden');
         resultContainer.innerHTML = '<p>正在生成中，请稍候...</p>';
 
-        fetch('/api/generate', {
+        fetch(batch ? '/api/batch' : '/api/generate', {
             method: 'POST',
             body: formData
         })
@@ -156,17 +284,37 @@ document.addEventListener('DOMContentLoaded', function () {
                 if (contentType && contentType.includes("application/json")) {
                     return response.json().then(data => ({ type: 'json', body: data }));
                 } else if (contentType && contentType.startsWith("image/")) {
-                    return response.blob().then(blob => ({ type: 'image', body: blob }));
+                    return response.blob().then(blob => ({ type: 'image', body: blob, metadata: metadataFromHeaders(response) }));
                 } else {
                     throw new Error('Unexpected response type from server.');
                 }
             })
             .then(data => {
-                if (data.type === 'json') {
-                    resultContainer.innerHTML = `<img src="${data.body.imageUrl}" alt="Generated Image">`;
+                if (data.type === 'json' && data.body.results) {
+                    renderBatch(data.body);
+                } else if (data.type === 'json') {
+                    const images = data.body.images || [{ url: data.body.imageUrl }];
+                    resultContainer.innerHTML = images.map(img => `
+                        <figure>
+                            <img src="${img.url}" alt="Generated Image">
+                            ${img.seed ? `<figcaption>种子 (Seed): ${img.seed}</figcaption>` : ''}
+                        </figure>`).join('');
+                    // Show the prompt the model actually received if it was translated
+                    if (data.body.translation) {
+                        const note = document.createElement('p');
+                        note.className = 'translation-note';
+                        note.textContent = `提示词已翻译为: ${data.body.translation.translated_prompt}`;
+                        resultContainer.prepend(note);
+                    }
+                    if (data.body.metadata) {
+                        resultContainer.appendChild(generationInfo(data.body.metadata));
+                    }
                 } else if (data.type === 'image') {
                     const imageUrl = URL.createObjectURL(data.body);
                     resultContainer.innerHTML = `<img src="${imageUrl}" alt="Generated Image">`;
+                    if (data.metadata) {
+                        resultContainer.appendChild(generationInfo(data.metadata));
+                    }
                 }
             })
             .catch(error => {
@@ -183,6 +331,69 @@ document.addEventListener('DOMContentLoaded', function () {
     stepsInput.addEventListener('input', function () {
     	stepsValue.textContent = this.value;
     });
+
+    document.querySelectorAll('.dynamic-param input[type="range"]').forEach(input => {
+        const valueEl = input.parentElement.querySelector('.range-value');
+        if (valueEl) {
+            input.addEventListener('input', () => valueEl.textContent = input.value);
+        }
+    });
+
+    // Load the style presets. Choosing one switches to its preferred model
+    // and fills in its default size and steps, which the user can still change.
+    fetch('/api/styles')
+        .then(response => response.json())
+        .then(presets => {
+            presets.forEach(preset => {
+                const option = document.createElement('option');
+                option.value = preset.name;
+                option.textContent = preset.description ? `${preset.name} - ${preset.description}` : preset.name;
+                option.dataset.preset = JSON.stringify(preset);
+                styleSelect.appendChild(option);
+            });
+        })
+        .catch(error => console.error('Error fetching style presets:', error));
+
+    styleSelect.addEventListener('change', function () {
+        const selectedOption = this.options[this.selectedIndex];
+        if (!selectedOption || !selectedOption.dataset.preset) {
+            return;
+        }
+        const preset = JSON.parse(selectedOption.dataset.preset);
+        if (preset.model && Array.from(modelSelect.options).some(option => option.value === preset.model)) {
+            modelSelect.value = preset.model;
+            modelSelect.dispatchEvent(new Event('change'));
+        }
+        // Stay within the limits the model change has just set.
+        if (preset.width) {
+            widthInput.value = Math.min(preset.width, widthInput.max || preset.width);
+        }
+        if (preset.height) {
+            heightInput.value = Math.min(preset.height, heightInput.max || preset.height);
+        }
+        if (preset.steps) {
+            stepsInput.value = Math.min(Math.max(preset.steps, stepsInput.min), stepsInput.max);
+            stepsValue.textContent = stepsInput.value;
+        }
+        const strengthInput = document.getElementById('strength');
+        if (preset.strength && !strengthInput.disabled) {
+            strengthInput.value = preset.strength;
+            strengthInput.parentElement.querySelector('.range-value').textContent = strengthInput.value;
+        }
+    });
+   
+    // Load the styles offered by the prompt optimizer
+    fetch('/api/optimize-prompt')
+    	.then(response => response.json())
+    	.then(data => {
+    		(data.styles || []).forEach(style => {
+    			const option = document.createElement('option');
+    			option.value = style;
+    			option.textContent = style;
+    			optimizeStyleSelect.appendChild(option);
+    		});
+    	})
+    	.catch(error => console.error('Error fetching prompt styles:', error));
    
     optimizeBtn.addEventListener('click', function() {
     	const currentPrompt = promptTextarea.value;
@@ -200,7 +411,7 @@ document.addEventListener('DOMContentLoaded', function () {
     		headers: {
     			'Content-Type': 'application/json',
     		},
-    		body: JSON.stringify({ prompt: currentPrompt }),
+    		body: JSON.stringify({ prompt: currentPrompt, style: optimizeStyleSelect.value }),
     	})
     	.then(response => {
     		if (!response.ok) {
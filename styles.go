@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"imageapi/config"
+	"imageapi/presets"
+)
+
+// stylePresets holds the style presets loaded from STYLES_DIR.
+var stylePresets *presets.Library
+
+// initializeStyles loads the style presets. Invalid presets are logged and skipped.
+func initializeStyles() {
+	dir := config.AppConfig.Settings.StylesDir
+	library, warnings := presets.LoadDir(dir)
+	for _, err := range warnings {
+		log.Printf("Warning: skipping style preset: %v", err)
+	}
+	stylePresets = library
+	log.Printf("Loaded %d style preset(s) from '%s'", len(library.List()), dir)
+}
+
+// applyStyle expands the style preset named in req, if any: the prompt is
+// inserted into the preset's template, and the preset's model fills in an
+// empty model. The preset's negative prompt, size, steps and strength are
+// only used where the request leaves them empty and the model supports them,
+// so a preset never makes an otherwise valid request fail. req is updated in
+// place.
+func applyStyle(req *generationRequest) error {
+	if req.Style == "" {
+		return nil
+	}
+	preset, ok := stylePresets.Get(req.Style)
+	if !ok {
+		return newValidationError([]FieldError{{Field: "style", Message: "unknown style '" + req.Style + "'"}})
+	}
+
+	// An empty prompt is left for validation to reject.
+	if strings.TrimSpace(req.Prompt) != "" {
+		req.Prompt = preset.Expand(req.Prompt)
+	}
+	if req.Model == "" {
+		req.Model = preset.Model
+	}
+	log.Printf("Applied style '%s': \"%s\"", preset.Name, req.Prompt)
+
+	// Unknown models are reported by validation.
+	_, caps, err := resolveModel(req.Model)
+	if err != nil {
+		return nil
+	}
+	if preset.NegativePrompt != "" && caps.Supports("negative_prompt") {
+		if req.NegativePrompt == "" {
+			req.NegativePrompt = preset.NegativePrompt
+		} else {
+			req.NegativePrompt += ", " + preset.NegativePrompt
+		}
+	}
+	if req.Width == 0 && preset.Width > 0 {
+		req.Width = min(preset.Width, orMax(caps.MaxWidth))
+	}
+	if req.Height == 0 && preset.Height > 0 {
+		req.Height = min(preset.Height, orMax(caps.MaxHeight))
+	}
+	if req.Steps == 0 && preset.Steps > 0 && caps.Supports("steps") {
+		req.Steps = min(max(preset.Steps, caps.MinSteps), orMax(caps.MaxSteps))
+	}
+	if req.Strength == 0 && preset.Strength > 0 && caps.Supports("strength") {
+		req.Strength = preset.Strength
+		if r, ok := caps.ParamRanges["strength"]; ok {
+			req.Strength = min(max(req.Strength, r.Min), r.Max)
+		}
+	}
+	return nil
+}
+
+// orMax returns limit, or the largest int if limit is zero (no limit).
+func orMax(limit int) int {
+	if limit == 0 {
+		return math.MaxInt
+	}
+	return limit
+}
+
+// handleGetStyles lists the style presets for the web UI.
+func handleGetStyles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stylePresets.List())
+}
+
+// handleAPIGetStyles lists the style presets for the external API.
+func handleAPIGetStyles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "Only GET method is allowed"))
+		return
+	}
+	handleGetStyles(w, r)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"imageapi/config"
+	"imageapi/providers"
+	"imageapi/translator"
+)
+
+// promptTranslator translates prompts for models flagged with EnglishPrompt.
+// It is nil when translation is disabled.
+var promptTranslator translator.Translator
+
+// initializeTranslator creates the translator selected in the TRANSLATION
+// config. Translation stays disabled if it cannot be created.
+func initializeTranslator() {
+	settings := config.AppConfig.Translation
+	if !settings.Enabled {
+		return
+	}
+	t, err := newTranslator(settings)
+	if err != nil {
+		log.Printf("Warning: prompt translation disabled: %v", err)
+		return
+	}
+	promptTranslator = t
+	log.Printf("Prompt translation enabled, using translator '%s'", t.Name())
+}
+
+// newTranslator creates the translator settings declare.
+func newTranslator(settings config.TranslationSettings) (translator.Translator, error) {
+	switch strings.ToLower(settings.Translator) {
+	case "", "llm":
+		backend, err := newPromptOptimizer(settings.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKEND: %w", err)
+		}
+		return translator.NewLLM(backend), nil
+	case "dictionary":
+		if settings.DictionaryFile == "" {
+			return nil, fmt.Errorf("DICTIONARY_FILE is not set")
+		}
+		return translator.LoadDictionary(settings.DictionaryFile)
+	default:
+		return nil, fmt.Errorf("unknown TRANSLATOR '%s', expected llm or dictionary", settings.Translator)
+	}
+}
+
+// promptTranslation tells the client how its prompt was translated.
+type promptTranslation struct {
+	SourceLanguage   string `json:"source_language"`
+	Translator       string `json:"translator"`
+	OriginalPrompt   string `json:"original_prompt"`
+	TranslatedPrompt string `json:"translated_prompt"`
+	// The negative prompt is only reported when it was translated as well.
+	OriginalNegativePrompt   string `json:"original_negative_prompt,omitempty"`
+	TranslatedNegativePrompt string `json:"translated_negative_prompt,omitempty"`
+}
+
+// translatePrompt translates the prompt and negative prompt of req into
+// English if the model asks for English prompts and they are written in
+// another language. req is updated in place. A failed translation keeps the
+// original prompts, since most models still cope with them.
+func translatePrompt(ctx context.Context, req *generationRequest, caps providers.ModelCapabilities) *promptTranslation {
+	if promptTranslator == nil || !caps.EnglishPrompt {
+		return nil
+	}
+	language := translator.DetectLanguage(req.Prompt)
+	negativeLanguage := translator.DetectLanguage(req.NegativePrompt)
+	if language == translator.English && negativeLanguage == translator.English {
+		return nil
+	}
+
+	if timeout := config.AppConfig.Translation.TimeoutSeconds; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	translation := &promptTranslation{
+		SourceLanguage:   language,
+		Translator:       promptTranslator.Name(),
+		OriginalPrompt:   req.Prompt,
+		TranslatedPrompt: req.Prompt,
+	}
+	if language != translator.English {
+		translated, err := promptTranslator.Translate(ctx, req.Prompt, language)
+		if err != nil {
+			log.Printf("Warning: failed to translate prompt, using the original: %v", err)
+			return nil
+		}
+		translation.TranslatedPrompt = translated
+	}
+	if negativeLanguage != translator.English {
+		translated, err := promptTranslator.Translate(ctx, req.NegativePrompt, negativeLanguage)
+		if err != nil {
+			log.Printf("Warning: failed to translate negative prompt, using the original: %v", err)
+		} else {
+			translation.OriginalNegativePrompt = req.NegativePrompt
+			translation.TranslatedNegativePrompt = translated
+			req.NegativePrompt = translated
+		}
+	}
+	if language == translator.English {
+		if translation.TranslatedNegativePrompt == "" {
+			return nil
+		}
+		translation.SourceLanguage = negativeLanguage
+	}
+
+	req.Prompt = translation.TranslatedPrompt
+	log.Printf("Translated prompt from '%s' for model '%s': \"%s\"", translation.SourceLanguage, req.Model, req.Prompt)
+	return translation
+}
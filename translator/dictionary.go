@@ -0,0 +1,115 @@
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Dictionary translates by replacing known phrases, preferring the longest
+// match. It needs no network access, which makes it a stand-in for an LLM in
+// tests and offline deployments; unknown words are kept as they are.
+type Dictionary struct {
+	phrases   map[string]string
+	maxLength int // Longest phrase, in runes
+}
+
+// NewDictionary creates a translator from phrase pairs.
+func NewDictionary(phrases map[string]string) *Dictionary {
+	d := &Dictionary{phrases: make(map[string]string, len(phrases))}
+	for phrase, translation := range phrases {
+		if phrase == "" {
+			continue
+		}
+		d.phrases[phrase] = translation
+		d.maxLength = max(d.maxLength, utf8.RuneCountInString(phrase))
+	}
+	return d
+}
+
+// LoadDictionary reads phrase pairs from a JSON object such as {"猫": "cat"}.
+func LoadDictionary(path string) (*Dictionary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var phrases map[string]string
+	if err := json.Unmarshal(data, &phrases); err != nil {
+		return nil, fmt.Errorf("failed to parse dictionary %s: %w", path, err)
+	}
+	return NewDictionary(phrases), nil
+}
+
+// Name returns the name of the backend.
+func (d *Dictionary) Name() string {
+	return "dictionary"
+}
+
+// Translate replaces every known phrase of text with its translation.
+func (d *Dictionary) Translate(ctx context.Context, text, from string) (string, error) {
+	runes := []rune(text)
+	var words []string
+	var pending strings.Builder // Untranslated text since the last match
+	flush := func() {
+		if s := strings.TrimSpace(pending.String()); s != "" {
+			words = append(words, s)
+		}
+		pending.Reset()
+	}
+
+	for i := 0; i < len(runes); {
+		matched := false
+		for n := min(d.maxLength, len(runes)-i); n > 0; n-- {
+			if translation, ok := d.phrases[string(runes[i:i+n])]; ok {
+				flush()
+				if translation != "" {
+					words = append(words, translation)
+				}
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			pending.WriteRune(translatePunctuation(runes[i]))
+			i++
+		}
+	}
+	flush()
+
+	var out strings.Builder
+	for _, w := range words {
+		// Punctuation sticks to the preceding word.
+		if out.Len() > 0 && !strings.ContainsAny(w[:1], ",.;:!?)") {
+			out.WriteByte(' ')
+		}
+		out.WriteString(w)
+	}
+	return out.String(), nil
+}
+
+// translatePunctuation maps full-width punctuation to its ASCII form.
+func translatePunctuation(r rune) rune {
+	switch r {
+	case '，', '、':
+		return ','
+	case '。':
+		return '.'
+	case '：':
+		return ':'
+	case '；':
+		return ';'
+	case '！':
+		return '!'
+	case '？':
+		return '?'
+	case '（':
+		return '('
+	case '）':
+		return ')'
+	}
+	return r
+}
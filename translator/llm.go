@@ -0,0 +1,41 @@
+package translator
+
+import (
+	"context"
+	"fmt"
+
+	"imageapi/optimizer"
+)
+
+// systemPromptFormat instructs the language model; %s is the source language.
+const systemPromptFormat = "You translate prompts for text-to-image models from %s into English. " +
+	"Translate faithfully without adding details, keep names, quoted text and English terms unchanged, " +
+	"and reply with the translation only."
+
+// LLM translates through a chat model, reusing a prompt optimizer backend
+// with a translation system prompt.
+type LLM struct {
+	chain *optimizer.Chain
+	name  string
+}
+
+// NewLLM creates a translator on top of the chat backend.
+func NewLLM(backend optimizer.PromptOptimizer) *LLM {
+	// The chain trims the quotes models like to wrap their answer in.
+	return &LLM{chain: optimizer.NewChain([]optimizer.PromptOptimizer{backend}, 0), name: backend.Name()}
+}
+
+// Name returns the name of the chat backend.
+func (t *LLM) Name() string {
+	return t.name
+}
+
+// Translate asks the chat model to translate text.
+func (t *LLM) Translate(ctx context.Context, text, from string) (string, error) {
+	language, ok := LanguageNames[from]
+	if !ok {
+		language = "the user's language"
+	}
+	translated, _, err := t.chain.Optimize(ctx, text, fmt.Sprintf(systemPromptFormat, language), "")
+	return translated, err
+}
@@ -0,0 +1,65 @@
+// Package translator translates prompts into English for models that follow
+// English prompts best.
+package translator
+
+import (
+	"context"
+	"unicode"
+)
+
+// Translator is implemented by every translation backend.
+type Translator interface {
+	// Name identifies the backend in logs and responses.
+	Name() string
+	// Translate translates text from the language with code from into English.
+	Translate(ctx context.Context, text, from string) (string, error)
+}
+
+// Language codes reported by DetectLanguage.
+const (
+	English  = "en"
+	Chinese  = "zh"
+	Japanese = "ja"
+	Korean   = "ko"
+	Russian  = "ru"
+)
+
+// LanguageNames maps the codes of DetectLanguage to English language names.
+var LanguageNames = map[string]string{
+	English:  "English",
+	Chinese:  "Chinese",
+	Japanese: "Japanese",
+	Korean:   "Korean",
+	Russian:  "Russian",
+}
+
+// DetectLanguage guesses the language of text from the scripts it uses. Any
+// Han, kana, Hangul or Cyrillic letter marks the text as non-English, since
+// those need translating even when mixed with English terms; text written in
+// Latin script only is reported as English.
+func DetectLanguage(text string) string {
+	var han, kana, hangul, cyrillic int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		}
+	}
+	switch {
+	case kana > 0:
+		return Japanese // Japanese mixes kana with Han characters
+	case han > 0 && han >= hangul && han >= cyrillic:
+		return Chinese
+	case hangul > 0 && hangul >= cyrillic:
+		return Korean
+	case cyrillic > 0:
+		return Russian
+	}
+	return English
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"imageapi/config"
+	"imageapi/providers"
+)
+
+// Validation modes, selected with the VALIDATION_MODE setting.
+const (
+	validationClamp  = "clamp"  // Adjust out-of-range values and drop unsupported ones
+	validationReject = "reject" // Fail the request instead
+)
+
+const (
+	// defaultImageDimension is used for a width or height the request leaves
+	// out, unless the model's maximum is smaller.
+	defaultImageDimension = 1024
+	// minImageDimension is the smallest width or height accepted for any model.
+	minImageDimension = 64
+)
+
+// FieldError describes one invalid field of a generation request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// newValidationError returns a 400 error listing every invalid field.
+func newValidationError(fields []FieldError) *apiError {
+	messages := make([]string, len(fields))
+	for i, f := range fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return &apiError{
+		Status:  http.StatusBadRequest,
+		Message: "Invalid request parameters: " + strings.Join(messages, "; "),
+		Fields:  fields,
+	}
+}
+
+// validateGenerationRequest resolves the model of req, then checks every
+// field against the model's capabilities. Missing values are filled with the
+// model defaults; values outside the model's limits are clamped or rejected
+// depending on VALIDATION_MODE. req is updated in place.
+func validateGenerationRequest(req *generationRequest) (providers.ImageProvider, providers.ModelCapabilities, error) {
+	provider, caps, err := resolveModel(req.Model)
+	if err != nil {
+		return nil, caps, err
+	}
+
+	v := &paramValidator{
+		model: req.Model,
+		clamp: config.AppConfig.Settings.ValidationMode != validationReject,
+	}
+	v.normalize(req, caps)
+	if len(v.errors) > 0 {
+		return nil, caps, newValidationError(v.errors)
+	}
+
+	// Check the request against the model's declared image input and tasks.
+	images := 0
+	if len(req.ImageBytes) > 0 || req.ImageURL != "" {
+		images = 1
+	}
+	hasMask := len(req.MaskBytes) > 0 || req.MaskURL != "" || len(req.MaskRects) > 0
+	if err := checkModelInput(req.Model, caps, images, hasMask); err != nil {
+		return nil, caps, err
+	}
+	return provider, caps, nil
+}
+
+// resolveModel looks up the provider and capabilities of a "provider/model" name.
+func resolveModel(fullModelName string) (providers.ImageProvider, providers.ModelCapabilities, error) {
+	fail := func(format string, args ...interface{}) error {
+		return newValidationError([]FieldError{{Field: "model", Message: fmt.Sprintf(format, args...)}})
+	}
+
+	if fullModelName == "" {
+		return nil, providers.ModelCapabilities{}, fail("is required")
+	}
+	providerName, modelName, err := providers.ParseModelName(fullModelName)
+	if err != nil {
+		return nil, providers.ModelCapabilities{}, fail("%s", err.Error())
+	}
+	provider, ok := providerRegistry[providerName]
+	if !ok {
+		return nil, providers.ModelCapabilities{}, fail("provider '%s' not found or not configured", providerName)
+	}
+	caps, ok := providers.FindModel(provider, modelName)
+	if !ok {
+		return nil, providers.ModelCapabilities{}, fail("model '%s' not found", fullModelName)
+	}
+	return provider, caps, nil
+}
+
+// paramValidator collects the field errors of one request.
+type paramValidator struct {
+	model  string
+	clamp  bool
+	errors []FieldError
+}
+
+func (v *paramValidator) fail(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// normalize validates each field of req against caps.
+func (v *paramValidator) normalize(req *generationRequest, caps providers.ModelCapabilities) {
+	if strings.TrimSpace(req.Prompt) == "" {
+		v.fail("prompt", "is required")
+	}
+
+	if req.N == 0 {
+		req.N = 1
+	}
+	req.N = v.intRange("n", req.N, 1, maxImagesPerRequest)
+
+	req.Width = v.dimension("width", req.Width, caps.MaxWidth, caps.SizeMultiple)
+	req.Height = v.dimension("height", req.Height, caps.MaxHeight, caps.SizeMultiple)
+
+	switch {
+	case !caps.Supports("steps"):
+		if req.Steps != 0 {
+			unsupportedValue(v, "steps", &req.Steps)
+		}
+	case req.Steps == 0:
+		req.Steps = caps.DefaultSteps
+	case caps.MinSteps > 0 || caps.MaxSteps > 0:
+		maxSteps := caps.MaxSteps
+		if maxSteps == 0 {
+			maxSteps = math.MaxInt32
+		}
+		req.Steps = v.intRange("steps", req.Steps, caps.MinSteps, maxSteps)
+	}
+
+	if req.Seed < 0 {
+		v.fail("seed", "must not be negative")
+	} else if req.Seed != 0 && !caps.Supports("seed") {
+		unsupportedValue(v, "seed", &req.Seed)
+	}
+
+	if req.NegativePrompt != "" && !caps.Supports("negative_prompt") {
+		unsupportedValue(v, "negative_prompt", &req.NegativePrompt)
+	}
+
+	req.Guidance = v.rangedParam("guidance", req.Guidance, caps)
+	req.Strength = v.rangedParam("strength", req.Strength, caps)
+
+	if len(req.LoRAs) > 0 {
+		if !caps.Supports("loras") {
+			unsupportedValue(v, "loras", &req.LoRAs)
+		} else {
+			v.loras(req.LoRAs, caps.MaxLoRAs)
+		}
+	}
+}
+
+// loras checks the LoRA adapters of a request, defaulting missing weights to 1.
+func (v *paramValidator) loras(loras []providers.LoRA, maxLoRAs int) {
+	if maxLoRAs > 0 && len(loras) > maxLoRAs {
+		v.fail("loras", "at most %d adapters are supported by model '%s'", maxLoRAs, v.model)
+	}
+	for i := range loras {
+		loras[i].Name = strings.TrimSpace(loras[i].Name)
+		switch {
+		case loras[i].Name == "":
+			v.fail("loras", "entry %d has no name", i+1)
+		case loras[i].Weight < 0:
+			v.fail("loras", "weight of '%s' must not be negative", loras[i].Name)
+		case loras[i].Weight == 0:
+			loras[i].Weight = 1
+		}
+	}
+}
+
+// dimension validates a width or height, filling in the default and snapping
+// the result to the model's size multiple.
+func (v *paramValidator) dimension(field string, value, maxValue, multiple int) int {
+	if maxValue == 0 {
+		maxValue = math.MaxInt32
+	}
+	if value == 0 {
+		value = min(defaultImageDimension, maxValue)
+	} else {
+		value = v.intRange(field, value, minImageDimension, maxValue)
+	}
+
+	if multiple > 1 && value%multiple != 0 {
+		snapped := (value + multiple/2) / multiple * multiple
+		if snapped > maxValue {
+			snapped = maxValue / multiple * multiple
+		}
+		if snapped < minImageDimension {
+			snapped = (minImageDimension + multiple - 1) / multiple * multiple
+		}
+		log.Printf("Snapped '%s' from %d to %d, a multiple of %d required by model '%s'", field, value, snapped, multiple, v.model)
+		value = snapped
+	}
+	return value
+}
+
+// rangedParam validates a float parameter such as guidance against the
+// model's ParamRanges, filling in the model default when it is zero.
+func (v *paramValidator) rangedParam(field string, value float64, caps providers.ModelCapabilities) float64 {
+	if !caps.Supports(field) {
+		if value != 0 {
+			unsupportedValue(v, field, &value)
+		}
+		return value
+	}
+	r, ok := caps.ParamRanges[field]
+	if !ok {
+		return value
+	}
+	if value == 0 {
+		return r.Default
+	}
+	if value < r.Min || value > r.Max {
+		if !v.clamp {
+			v.fail(field, "must be between %g and %g", r.Min, r.Max)
+			return value
+		}
+		clamped := min(max(value, r.Min), r.Max)
+		log.Printf("Clamped '%s' from %g to %g for model '%s'", field, value, clamped, v.model)
+		return clamped
+	}
+	return value
+}
+
+// intRange checks that value lies in [min, max], clamping it in clamp mode.
+func (v *paramValidator) intRange(field string, value, minValue, maxValue int) int {
+	if value >= minValue && value <= maxValue {
+		return value
+	}
+	if !v.clamp {
+		v.fail(field, "must be between %d and %d", minValue, maxValue)
+		return value
+	}
+	clamped := min(max(value, minValue), maxValue)
+	log.Printf("Clamped '%s' from %d to %d for model '%s'", field, value, clamped, v.model)
+	return clamped
+}
+
+// unsupportedValue handles a parameter the model does not take: it is dropped in
+// clamp mode and rejected otherwise.
+func unsupportedValue[T any](v *paramValidator, field string, value *T) {
+	if !v.clamp {
+		v.fail(field, "is not supported by model '%s'", v.model)
+		return
+	}
+	log.Printf("Ignoring '%s', which model '%s' does not support", field, v.model)
+	var zero T
+	*value = zero
+}
+
+// decodeJSONRequest decodes a JSON request body into dst. A value of the
+// wrong type is reported as a field error rather than a generic decode error.
+func decodeJSONRequest(r *http.Request, dst interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return newValidationError([]FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)}})
+	}
+	return newAPIError(http.StatusBadRequest, "Invalid JSON request body")
+}
+
+// formParser reads optional numeric form fields, recording a FieldError for
+// each malformed value instead of silently dropping it.
+type formParser struct {
+	r      *http.Request
+	errors []FieldError
+}
+
+// Int parses the named field into dst if it is present.
+func (p *formParser) Int(field string, dst *int) {
+	if value := p.r.FormValue(field); value != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			p.errors = append(p.errors, FieldError{Field: field, Message: fmt.Sprintf("'%s' is not an integer", value)})
+			return
+		}
+		*dst = n
+	}
+}
+
+// Int64 parses the named field into dst if it is present.
+func (p *formParser) Int64(field string, dst *int64) {
+	if value := p.r.FormValue(field); value != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			p.errors = append(p.errors, FieldError{Field: field, Message: fmt.Sprintf("'%s' is not an integer", value)})
+			return
+		}
+		*dst = n
+	}
+}
+
+// Float parses the named field into dst if it is present.
+func (p *formParser) Float(field string, dst *float64) {
+	if value := p.r.FormValue(field); value != "" {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			p.errors = append(p.errors, FieldError{Field: field, Message: fmt.Sprintf("'%s' is not a number", value)})
+			return
+		}
+		*dst = f
+	}
+}
+
+// LoRAs parses the named field into dst if it is present. The value lists
+// adapters separated by commas, each optionally followed by ":weight", e.g.
+// "user/style-lora:0.8,user/detail-lora".
+func (p *formParser) LoRAs(field string, dst *[]providers.LoRA) {
+	value := p.r.FormValue(field)
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	var loras []providers.LoRA
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lora := providers.LoRA{Name: part}
+		if i := strings.LastIndex(part, ":"); i >= 0 {
+			weight, err := strconv.ParseFloat(strings.TrimSpace(part[i+1:]), 64)
+			if err != nil {
+				p.errors = append(p.errors, FieldError{Field: field, Message: fmt.Sprintf("invalid adapter '%s', expected name or name:weight", part)})
+				return
+			}
+			lora = providers.LoRA{Name: strings.TrimSpace(part[:i]), Weight: weight}
+		}
+		loras = append(loras, lora)
+	}
+	*dst = loras
+}
+
+// Err returns a validation error listing every malformed field, or nil.
+func (p *formParser) Err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+	return newValidationError(p.errors)
+}